@@ -4,9 +4,14 @@ import (
 	"flag"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/teasherm/fueleconomy/auth"
+	"github.com/teasherm/fueleconomy/cache"
 	"github.com/teasherm/fueleconomy/global"
 	"github.com/teasherm/fueleconomy/handlers"
+	"github.com/teasherm/fueleconomy/logging"
+	"github.com/teasherm/fueleconomy/ratelimit"
 	"github.com/teasherm/fueleconomy/workers"
 )
 
@@ -16,24 +21,86 @@ var (
 )
 
 func main() {
-	global.InitLogger(os.Stdout)
+	global.InitLogger(os.Stdout, "info", "json")
 
-	dbConfig, err := global.GetDbConfig()
+	config, err := global.GetConfig()
 	if err != nil {
-		global.Logger.Fatalln(err)
+		logging.Fatal(global.Logger, "Config not read", "error", err)
+	}
+	if config.LogLevel != "" || config.LogFormat != "" {
+		err = global.InitLogger(os.Stdout, valueOr(config.LogLevel, "info"), valueOr(config.LogFormat, "json"))
+		if err != nil {
+			logging.Fatal(global.Logger, "Logger not configured", "error", err)
+		}
+	}
+	err = global.InitTracer(config)
+	if err != nil {
+		logging.Fatal(global.Logger, "Tracer not configured", "error", err)
+	}
+	global.Mirrors = config.Mirrors
+	if config.QualityAbortThreshold != nil {
+		global.QualityAbortThreshold = *config.QualityAbortThreshold
+	}
+	if config.WithdrawnGraceDays != nil {
+		global.WithdrawnGracePeriod = time.Duration(*config.WithdrawnGraceDays) * 24 * time.Hour
+	}
+
+	for name, plan := range config.RateLimitPlans {
+		global.RateLimitPlans[name] = plan
+	}
+	if config.ResponseCacheEntries != nil || config.ResponseCacheBytes != nil {
+		stats := cache.Responses.Stats()
+		if config.ResponseCacheEntries != nil {
+			stats.MaxEntries = *config.ResponseCacheEntries
+		}
+		if config.ResponseCacheBytes != nil {
+			stats.MaxBytes = *config.ResponseCacheBytes
+		}
+		cache.Responses.SetLimits(stats.MaxEntries, stats.MaxBytes)
 	}
 
-	err = global.InitDb("postgres", dbConfig)
+	err = global.InitDb("postgres", config.Db)
 	if err != nil {
-		global.Logger.Fatalln(err)
+		logging.Fatal(global.Logger, "Database not opened", "error", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		err = runImport(os.Args[2:])
+		if err != nil {
+			logging.Fatal(global.Logger, "Import failed", "error", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		err = runKeys(os.Args[2:])
+		if err != nil {
+			logging.Fatal(global.Logger, "Key not created", "error", err)
+		}
+		return
 	}
 
 	flag.Parse()
+	if config.RateLimitStore == "postgres" {
+		store, err := ratelimit.NewPostgresStore()
+		if err != nil {
+			logging.Fatal(global.Logger, "Rate limit store not opened", "error", err)
+		}
+		ratelimit.Limiter = store
+		store.StartPruner(10 * time.Minute)
+	}
+	auth.StartUsageFlusher(time.Minute)
 	workers.StartDispatcher(*NWorkers)
 
-	global.Logger.Println("server listening at: ", *HTTPAddr)
+	global.Logger.Info("Server listening", "addr", *HTTPAddr)
 
 	if err := http.ListenAndServe(*HTTPAddr, handlers.NewRouter()); err != nil {
-		global.Logger.Fatalln(err.Error())
+		logging.Fatal(global.Logger, "Server stopped", "error", err)
+	}
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
 	}
+	return value
 }
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -9,11 +14,26 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/teasherm/fueleconomy/auth"
+	"github.com/teasherm/fueleconomy/cache"
+	"github.com/teasherm/fueleconomy/export"
 	"github.com/teasherm/fueleconomy/global"
 	"github.com/teasherm/fueleconomy/handlers"
 	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/ratelimit"
+	"github.com/teasherm/fueleconomy/tracing"
 	"github.com/teasherm/fueleconomy/workers"
 )
 
@@ -32,9 +52,25 @@ func readFixture(fname string) (b []byte, err error) {
 	return ioutil.ReadFile(filePath)
 }
 
+func doWithKey(method string, url string, key string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return http.DefaultClient.Do(req)
+}
+
 // Mocks
 var testServer *httptest.Server
 
+// Keys made in setup
+var testAdminKey string
+var testIngestKey string
+var testReadKey string
+
 type testFuelPricesFetcher struct{}
 
 func (t testFuelPricesFetcher) Fetch(ignored string) ([]byte, error) {
@@ -54,6 +90,27 @@ func (t testVehiclesFetcher) Fetch(target string) ([]byte, error) {
 	}
 }
 
+// Emissions for a vehicle that isn't in vehicles.xml alongside the fixtures
+type testOrphansFetcher struct{}
+
+func (t testOrphansFetcher) Fetch(target string) ([]byte, error) {
+	data, err := readFixture("emissions.xml")
+	orphan := "<emissionsInfo><efid>CFMXT04.65H9</efid><id>2</id><salesArea>3</salesArea><score>2.0</score>" +
+		"<scoreAlt>-1.0</scoreAlt><smartwayScore>-1</smartwayScore><standard>B8</standard><stdText>Bin 8</stdText>" +
+		"</emissionsInfo></emissionsInfoes>"
+	return bytes.Replace(data, []byte("</emissionsInfoes>"), []byte(orphan), 1), err
+}
+
+// The vehicles fixture with an emissions release that can't be decoded
+type testBadEmissionsFetcher struct{}
+
+func (t testBadEmissionsFetcher) Fetch(target string) ([]byte, error) {
+	if target == "emissions" {
+		return []byte("<emissionsInfoes><emissionsInfo>"), nil
+	}
+	return testVehiclesFetcher{}.Fetch(target)
+}
+
 type flatVehicleResponse struct {
 	Vehicle models.Vehicle `json:"vehicle"`
 }
@@ -111,6 +168,283 @@ func TestVehicleGetOne(t *testing.T) {
 	if vehicle.Fuels[0].MpgCity != 19.0 {
 		t.Error("Vehicle get one parsed MPG City incorrectly")
 	}
+
+	if vehicle.Rankings == nil || vehicle.Rankings.GroupSize != 1 {
+		t.Error("Vehicle get one missing rankings")
+	}
+}
+
+func TestVehicleGetOneNotFound(t *testing.T) {
+	resp, err := http.Get(fmt.Sprintf("%s/vehicle/999999", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("Vehicle get one for missing vehicle not a 404")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var problem handlers.Problem
+	err = json.Unmarshal(body, &problem)
+	if err != nil {
+		t.Error("Vehicle get one for missing vehicle didn't send a single JSON body")
+	}
+	if problem.Code != "not_found" || problem.RequestID != resp.Header.Get(handlers.RequestIDHeader) {
+		t.Error("Vehicle get one for missing vehicle problem details wrong")
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	for requestID, kept := range map[string]bool{
+		"client-trace.42:a_b": true,
+		"has spaces":          false,
+		"":                    false,
+	} {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/vehicle/999999", testServer.URL), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if requestID != "" {
+			req.Header.Set(handlers.RequestIDHeader, requestID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var problem handlers.Problem
+		err = json.NewDecoder(resp.Body).Decode(&problem)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		echoed := resp.Header.Get(handlers.RequestIDHeader)
+		if echoed == "" || problem.RequestID != echoed {
+			t.Errorf("Request ID %q not sent in header and problem", requestID)
+		}
+		if kept != (echoed == requestID) {
+			t.Errorf("Request ID %q kept is %v, want %v", requestID, echoed == requestID, kept)
+		}
+	}
+}
+
+func TestInvalidParameters(t *testing.T) {
+	for query, param := range map[string]string{
+		"year=nineteen":                "year",
+		"cityShare=60&highwayShare=60": "highwayShare",
+		"milesPerYear=-5":              "milesPerYear",
+		"pageLength=1000":              "pageLength",
+		"page=x":                       "page",
+	} {
+		resp, err := http.Get(fmt.Sprintf("%s/vehicles?%s", testServer.URL, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		var problem handlers.Problem
+		err = json.Unmarshal(body, &problem)
+		if resp.StatusCode != http.StatusBadRequest || err != nil ||
+			problem.Code != "invalid_parameter" || problem.Param != param {
+			t.Errorf("Vehicles get many with %s not an invalid %s problem", query, param)
+		}
+	}
+}
+
+func getWithHeader(url string, header string, value string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(header, value)
+	return http.DefaultClient.Do(req)
+}
+
+func TestVehicleCaching(t *testing.T) {
+	var vehicleGetOneUrl = fmt.Sprintf("%s/vehicle/1?cityShare=55", testServer.URL)
+	resp, err := http.Get(vehicleGetOneUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" || lastModified == "" || resp.Header.Get("Cache-Control") == "" {
+		t.Fatal("Vehicle caching headers missing")
+	}
+
+	resp, err = getWithHeader(vehicleGetOneUrl, "If-None-Match", etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Error("Vehicle with a matching ETag not a 304")
+	}
+	resp, err = getWithHeader(vehicleGetOneUrl, "If-Modified-Since", lastModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Error("Vehicle not modified since not a 304")
+	}
+
+	// The default profile is the same query
+	resp, err = getWithHeader(fmt.Sprintf("%s/vehicle/1", testServer.URL), "If-None-Match", etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Error("Vehicle under an equivalent profile not a 304")
+	}
+	resp, err = getWithHeader(fmt.Sprintf("%s/vehicle/1?cityShare=20", testServer.URL), "If-None-Match", etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Vehicle under another profile not a 200")
+	}
+
+	var vehicleGetManyUrl = fmt.Sprintf("%s/vehicles?make=Alfa", testServer.URL)
+	resp, err = http.Get(vehicleGetManyUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manyEtag := resp.Header.Get("ETag")
+	resp, err = getWithHeader(vehicleGetManyUrl, "If-None-Match", manyEtag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manyEtag == "" || resp.StatusCode != http.StatusNotModified {
+		t.Error("Vehicles with a matching ETag not a 304")
+	}
+
+	// Ingests bump the dataset version
+	work, err := workers.GenerateWorkRequest(global.Db, "stats", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = work.DoWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = getWithHeader(vehicleGetOneUrl, "If-None-Match", etag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("ETag") == etag {
+		t.Error("Vehicle ETag not changed by an ingest")
+	}
+	resp, err = getWithHeader(vehicleGetManyUrl, "If-None-Match", manyEtag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Vehicles ETag not changed by an ingest")
+	}
+
+	// As do ingests that fail, which may have written part of the dataset
+	work = workers.WorkRequest{
+		Target: "stats",
+		Action: func(f workers.Fetcher) error { return errors.New("Partly written") }}
+	err = work.DoWork()
+	if err == nil {
+		t.Fatal("Failing ingest succeeded")
+	}
+	version, err := workers.GetDatasetVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.JobID != work.Job.ID {
+		t.Error("Dataset version not bumped by a failed ingest")
+	}
+	resp, err = getWithHeader(vehicleGetManyUrl, "If-None-Match", resp.Header.Get("ETag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Vehicles ETag not changed by a failed ingest")
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	var vehicleGetOneUrl = fmt.Sprintf("%s/vehicle/1?milesPerYear=12345", testServer.URL)
+	hits := cache.Responses.Stats().Hits
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(vehicleGetOneUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatal("Vehicle get one not a 200")
+		}
+	}
+	if cache.Responses.Stats().Hits != hits+1 {
+		t.Error("Vehicle response not cached")
+	}
+
+	// A fuel prices ingest clears responses and refreshes fuel prices
+	fuelPricesInvalidations := cache.FuelPricesStats().Invalidations
+	work := workers.WorkRequest{
+		Target:  "fuelprices",
+		Fetcher: testFuelPricesFetcher{},
+		Action:  workers.IngestFuelPrices}
+	err := work.DoWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Responses.Stats().Entries != 0 || cache.FuelPricesStats().Invalidations != fuelPricesInvalidations+1 {
+		t.Error("Fuel prices ingest didn't invalidate the cache")
+	}
+
+	resp, err := doWithKey("GET", fmt.Sprintf("%s/admin/cache", testServer.URL), testAdminKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var cr handlers.CacheResponse
+	err = json.NewDecoder(resp.Body).Decode(&cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Responses.Hits == 0 || cr.Responses.MaxEntries == 0 {
+		t.Error("Cache stats wrong")
+	}
+}
+
+func TestVehicleGetOneSparse(t *testing.T) {
+	var vehicleGetOneUrl = fmt.Sprintf("%s/vehicle/1?fields=make,fuels.mpgCity", testServer.URL)
+	req, err := http.NewRequest("GET", vehicleGetOneUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Vehicle get one sparse http request not successful")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var fv flatVehicleResponse
+	err = json.Unmarshal(body, &fv)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	vehicle := fv.Vehicle
+
+	if vehicle.Make != "Alfa Romeo" || vehicle.Model != "" {
+		t.Error("Vehicle get one sparse returned wrong fields")
+	}
+
+	if len(vehicle.EmissionsInfo) != 0 {
+		t.Error("Vehicle get one sparse included emissions info")
+	}
+
+	if len(vehicle.Fuels) != 1 || vehicle.Fuels[0].MpgCity != 19.0 || vehicle.Fuels[0].FuelType != "" {
+		t.Error("Vehicle get one sparse returned wrong fuel fields")
+	}
 }
 
 func TestVehicleGetManyExact(t *testing.T) {
@@ -161,6 +495,205 @@ func TestVehicleGetManyExact(t *testing.T) {
 	}
 }
 
+func getVehicleCount(t *testing.T, query string) int {
+	resp, err := http.Get(fmt.Sprintf("%s/vehicles?%s", testServer.URL, query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var fvs flatVehiclesResponse
+	err = json.Unmarshal(body, &fvs)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	return len(fvs.Vehicles)
+}
+
+func TestVehicleGetManyWithdrawn(t *testing.T) {
+	_, err := global.Db.Exec("UPDATE vehicles SET status = 'withdrawn' WHERE epa_id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Publish(cache.Invalidation{Target: "vehicles"})
+	if getVehicleCount(t, "year=1985") != 0 {
+		t.Error("Vehicles get many returned withdrawn vehicle")
+	}
+	if getVehicleCount(t, "year=1985&includeWithdrawn=true") != 1 {
+		t.Error("Vehicles get many didn't return withdrawn vehicle when asked")
+	}
+
+	// Reappearing in the feed makes a vehicle active again
+	err = workers.IngestVehicles(testVehiclesFetcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getVehicleCount(t, "year=1985") != 1 {
+		t.Error("Vehicle in feed not active again")
+	}
+}
+
+func TestWithdrawnNotListed(t *testing.T) {
+	corolla := func(epaID int, model string, status string, co2 float64) *models.Vehicle {
+		v := &models.Vehicle{EpaID: epaID, Year: 2014, Make: "Toyota", Model: model, Status: status,
+			SizeClass: "Compact Cars", DriveAxleType: "Front-Wheel Drive", F1Co2Tailpipe: co2, F1MpgComb: 30}
+		v.SearchDocument = models.NewSearchDocument(v)
+		return v
+	}
+	_, err := global.Db.InsertMany("vehicles",
+		corolla(900041, "Corolla", models.VehicleActive, 300),
+		corolla(900042, "Corolla iM", models.VehicleWithdrawn, 250),
+		corolla(900043, "Corolla XRS", models.VehicleDeleted, 200),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Publish(cache.Invalidation{Target: "vehicles"})
+	defer func() {
+		global.Db.Exec("DELETE FROM vehicles WHERE epa_id BETWEEN 900041 AND 900043")
+		cache.Publish(cache.Invalidation{Target: "vehicles"})
+		workers.ComputeStatistics(nil)
+	}()
+
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"Corolla"}},
+		{"&includeWithdrawn=true", []string{"Corolla", "Corolla iM"}},
+	} {
+		resp, err := http.Get(fmt.Sprintf("%s/models?year=2014&make=Toyota%s", testServer.URL, c.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+
+		var mr handlers.ModelsResponse
+		err = json.Unmarshal(body, &mr)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if !reflect.DeepEqual(mr.Models, c.want) {
+			t.Errorf("Catalog models%s wrong: %v", c.query, mr.Models)
+		}
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/autocomplete?q=toyota+corolla", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var ar handlers.AutocompleteResponse
+	err = json.Unmarshal(body, &ar)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(ar.Suggestions) != 1 || ar.Suggestions[0].Text != "Toyota Corolla" {
+		t.Errorf("Autocomplete suggested unlisted vehicles: %+v", ar.Suggestions)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/search?q=corolla", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	var fvs flatVehiclesResponse
+	err = json.Unmarshal(body, &fvs)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(fvs.Vehicles) != 1 || fvs.Vehicles[0].EpaID != 900041 {
+		t.Errorf("Vehicle search returned unlisted vehicles: %+v", fvs.Vehicles)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/trends?make=Toyota&from=2014&to=2014", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	var tr handlers.TrendsResponse
+	err = json.Unmarshal(body, &tr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(tr.Series) != 1 || tr.Series[0].Count != 1 {
+		t.Errorf("Trends counted unlisted vehicles: %+v", tr.Series)
+	}
+
+	for _, c := range []struct {
+		query string
+		want  []int
+	}{
+		{"&includeWithdrawn=true", []int{900042}},
+		{"", []int{}},
+	} {
+		resp, err = http.Get(fmt.Sprintf("%s/vehicle/900041/alternatives?rankBy=co2%s", testServer.URL, c.query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err = ioutil.ReadAll(resp.Body)
+
+		var alts handlers.AlternativesResponse
+		err = json.Unmarshal(body, &alts)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		epaIDs := make([]int, 0)
+		for _, alt := range alts.Alternatives {
+			epaIDs = append(epaIDs, alt.Vehicle.EpaID)
+		}
+		if !reflect.DeepEqual(epaIDs, c.want) {
+			t.Errorf("Alternatives%s wrong: %v", c.query, epaIDs)
+		}
+	}
+
+	// Withdrawn vehicles can be looked up by ID, deleted ones can't
+	resp, err = http.Get(fmt.Sprintf("%s/vehicles/batch?ids=900041,900042,900043", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	var fb flatBatchResponse
+	err = json.Unmarshal(body, &fb)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(fb.Vehicles) != 2 || fb.Vehicles[1].EpaID != 900042 || !reflect.DeepEqual(fb.NotFound, []int{900043}) {
+		t.Errorf("Vehicle get batch with deleted vehicle wrong: %+v", fb)
+	}
+
+	err = workers.ComputeStatistics(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(fmt.Sprintf("%s/stats?groupBy=year&group=2014&metric=co2", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	var sr handlers.StatsResponse
+	err = json.Unmarshal(body, &sr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(sr.Groups) != 1 || sr.Groups[0].Metrics["co2"].Count != 1 {
+		t.Errorf("Stats counted unlisted vehicles: %+v", sr.Groups)
+	}
+}
+
 func TestVehicleGetManyFuzzy(t *testing.T) {
 	var vehicleGetManyUrl = fmt.Sprintf("%s/vehicles?make=alfa", testServer.URL)
 	req, err := http.NewRequest("GET", vehicleGetManyUrl, nil)
@@ -193,22 +726,1496 @@ func TestVehicleGetManyFuzzy(t *testing.T) {
 	}
 }
 
-// Setup
-func setup() (err error) {
-	workRequest := workers.WorkRequest{
-		Target:  "fuelprices",
-		Fetcher: testFuelPricesFetcher{},
-		Action:  workers.IngestFuelPrices}
-	err = workRequest.DoWork()
+func TestVehicleFacets(t *testing.T) {
+	_, err := global.Db.InsertMany("vehicles",
+		&models.Vehicle{EpaID: 900001, Status: models.VehicleActive, Year: 2015, Make: "Toyota",
+			Model: "Camry", FuelType: "Regular"},
+		&models.Vehicle{EpaID: 900002, Status: models.VehicleActive, Year: 2015, Make: "Toyota",
+			Model: "Prius", FuelType: "Regular"},
+		&models.Vehicle{EpaID: 900003, Status: models.VehicleActive, Year: 2015, Make: "Honda",
+			Model: "Accord", FuelType: "Premium"},
+		&models.Vehicle{EpaID: 900004, Status: models.VehicleActive, Year: 2016, Make: "Honda",
+			Model: "Civic", FuelType: "Regular"},
+	)
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
+	defer global.Db.Conn.Exec("DELETE FROM vehicles WHERE epa_id BETWEEN 900001 AND 900004")
 
-	workRequest = workers.WorkRequest{
-		Target:  "vehicles",
-		Fetcher: testVehiclesFetcher{},
-		Action:  workers.IngestVehicles}
-	err = workRequest.DoWork()
+	var vehicleFacetsUrl = fmt.Sprintf("%s/vehicles?make=honda&pageLength=1&facets=make,fuelType,year,yearBucket",
+		testServer.URL)
+	req, err := http.NewRequest("GET", vehicleFacetsUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Vehicles get many with facets not a 200")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var vr handlers.VehiclesResponse
+	err = json.Unmarshal(body, &vr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// Counts are under the filters, and cover every match rather than the page
+	want := map[string][]models.FacetCount{
+		"make":       {{Value: "Honda", Count: 2}},
+		"fuelType":   {{Value: "Premium", Count: 1}, {Value: "Regular", Count: 1}},
+		"year":       {{Value: "2015", Count: 1}, {Value: "2016", Count: 1}},
+		"yearBucket": {{Value: "2015", Count: 2}},
+	}
+	if !reflect.DeepEqual(vr.Facets, want) {
+		t.Errorf("Vehicles get many facet counts wrong: %+v", vr.Facets)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/vehicles?facets=make,colour", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("Vehicles get many with unsupported facet not a 400")
+	}
+}
+
+func TestVehicleSearch(t *testing.T) {
+	var vehicleSearchUrl = fmt.Sprintf("%s/search?q=romeo+spyder", testServer.URL)
+	req, err := http.NewRequest("GET", vehicleSearchUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Vehicle search not a 200")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var fvs flatVehiclesResponse
+	err = json.Unmarshal(body, &fvs)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if len(fvs.Vehicles) != 1 {
+		t.Error("Vehicle search with typo failure")
+	}
+
+	vehicle := fvs.Vehicles[0]
+
+	if vehicle.Model != "Spider Veloce 2000" {
+		t.Error("Vehicle search returned wrong vehicle")
+	}
+
+	// Every vehicle isn't a search result
+	for _, query := range []string{"", "q=", "q=+-+"} {
+		resp, err := http.Get(fmt.Sprintf("%s/search?%s", testServer.URL, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		var problem handlers.Problem
+		err = json.Unmarshal(body, &problem)
+		if resp.StatusCode != http.StatusBadRequest || err != nil || problem.Param != "q" {
+			t.Errorf("Vehicle search with %q not an invalid q problem", query)
+		}
+	}
+}
+
+type flatBatchResponse struct {
+	Vehicles []models.Vehicle `json:"vehicles"`
+	NotFound []int            `json:"notFound"`
+}
+
+func TestVehicleGetBatch(t *testing.T) {
+	var vehicleGetBatchUrl = fmt.Sprintf("%s/vehicles/batch?ids=2,1", testServer.URL)
+	req, err := http.NewRequest("GET", vehicleGetBatchUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Vehicle get batch not a 200")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var fb flatBatchResponse
+	err = json.Unmarshal(body, &fb)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if len(fb.Vehicles) != 1 || fb.Vehicles[0].EpaID != 1 {
+		t.Error("Vehicle get batch returned wrong vehicles")
+	}
+
+	if len(fb.Vehicles[0].EmissionsInfo) != 2 {
+		t.Error("Vehicle get batch parsed too few EmissionsInfoes")
+	}
+
+	if len(fb.NotFound) != 1 || fb.NotFound[0] != 2 {
+		t.Error("Vehicle get batch didn't report missing id")
+	}
+
+	resp, err = http.Post(testServer.URL+"/vehicles/batch", "application/json",
+		strings.NewReader(`{"ids": [2, 1]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	fb = flatBatchResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&fb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || len(fb.Vehicles) != 1 || fb.Vehicles[0].EpaID != 1 {
+		t.Errorf("Vehicle post batch returned wrong vehicles: %d %+v", resp.StatusCode, fb)
+	}
+
+	// A body over the limit is refused before it's decoded
+	padding := strings.Repeat(" ", int(handlers.BatchBodyMax))
+	resp, err = http.Post(testServer.URL+"/vehicles/batch", "application/json",
+		strings.NewReader(`{"ids": [1]`+padding+`}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var problem handlers.Problem
+	err = json.NewDecoder(resp.Body).Decode(&problem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || problem.Code != "payload_too_large" {
+		t.Errorf("Vehicle post batch over the body limit not a 413: %d %+v", resp.StatusCode, problem)
+	}
+
+	// A body that can't be read for any other reason isn't too large
+	req, err = http.NewRequest("POST", "/vehicles/batch", iotest.ErrReader(errors.New("Connection reset")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.VehicleGetBatch(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Vehicle post batch with an unreadable body not a 400: %d", rec.Code)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	snapshotDir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(snapshotDir)
+	global.SnapshotDir = snapshotDir
+	global.MigrationsDir = getPackagePath("migrations")
+
+	err = workers.CreateSnapshot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshotsUrl = fmt.Sprintf("%s/snapshots", testServer.URL)
+	resp, err := http.Get(snapshotsUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var sr handlers.SnapshotsResponse
+	err = json.Unmarshal(body, &sr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(sr.Snapshots) != 1 {
+		t.Fatal("Snapshots didn't list the snapshot")
+	}
+	for _, table := range sr.Snapshots[0].Tables {
+		if table.Name == "vehicles" && table.Rows != 1 {
+			t.Error("Snapshot has wrong vehicles row count")
+		}
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/snapshots/latest/vehicles.ndjson.gz", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Error("Snapshot file download not a 200")
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/snapshots/20000101T000000Z", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Error("Missing snapshot not a 404")
+	}
+
+	// A snapshot started in a second another is being created in fails
+	// rather than sharing its directory
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		version := models.NewSnapshotVersion(now.Add(time.Duration(i) * time.Second))
+		err = os.Mkdir(filepath.Join(snapshotDir, version+".partial"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if workers.CreateSnapshot(nil) == nil {
+		t.Error("Snapshot created alongside one being created in the same second")
+	}
+}
+
+func TestLocalFetcherImport(t *testing.T) {
+	fetcher := workers.NewLocalFetcher(getPackagePath("models/fixtures"))
+	err := workers.IngestFuelPrices(fetcher)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	_, err = fetcher.Fetch("missing")
+	if err == nil {
+		t.Error("Local fetcher fetched unknown data")
+	}
+	err = workers.IngestVehicles(fetcher)
+	if err != nil {
+		t.Errorf("Local fetcher vehicles import from a directory failed: %s", err)
+	}
+
+	// An archive of a release, with vehicles zipped inside it as EPA
+	// distributes them
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, fname := range []string{"vehicles.xml", "emissions.xml", "fuel_prices.xml"} {
+		data, err := readFixture(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fname == "vehicles.xml" {
+			var inner bytes.Buffer
+			izw := zip.NewWriter(&inner)
+			w, err := izw.Create(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data)
+			izw.Close()
+			data, fname = inner.Bytes(), fname+".zip"
+		}
+		w, err := zw.Create("release/" + fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	zw.Close()
+	archiveFile, err := ioutil.TempFile("", "release*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archiveFile.Name())
+	archiveFile.Write(archive.Bytes())
+	archiveFile.Close()
+
+	err = workers.IngestVehicles(workers.NewLocalFetcher(archiveFile.Name()))
+	if err != nil {
+		t.Errorf("Local fetcher vehicles import from a zip failed: %s", err)
+	}
+	stdinFetcher := &workers.LocalFetcher{Path: "-", Stdin: bytes.NewReader(archive.Bytes())}
+	err = workers.IngestVehicles(stdinFetcher)
+	if err != nil {
+		t.Errorf("Local fetcher vehicles import from a zip on stdin failed: %s", err)
+	}
+	err = workers.IngestFuelPrices(stdinFetcher)
+	if err != nil {
+		t.Errorf("Local fetcher fuel prices import from a zip on stdin failed: %s", err)
+	}
+
+	// A lone document on stdin is only the data it holds
+	emissions, err := readFixture("emissions.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	work, err := workers.GenerateWorkRequest(global.Db, "emissions", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdinFetcher = &workers.LocalFetcher{Path: "-", Stdin: bytes.NewReader(emissions)}
+	work.Fetcher = stdinFetcher
+	err = work.DoWork()
+	if err != nil {
+		t.Errorf("Local fetcher emissions import from stdin failed: %s", err)
+	}
+	_, err = stdinFetcher.Fetch("vehicles")
+	if err == nil {
+		t.Error("Local fetcher fetched vehicles from emissions on stdin")
+	}
+}
+
+func TestDecodeCSV(t *testing.T) {
+	xmlData, err := readFixture("emissions.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvData, err := readFixture("emissions.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromXml, err := models.DecodeRawEmissionsInfoes(xmlData)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	fromCsv, err := models.DecodeRawEmissionsInfoes(csvData)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !reflect.DeepEqual(fromXml, fromCsv) {
+		t.Error("CSV emissions info decoded differently from XML")
+	}
+
+	drifted := []byte("efid,id,salesArea,score,scoreAlt,smartwayScore,standard,stdTxt\n")
+	_, err = models.DecodeRawEmissionsInfoes(drifted)
+	if _, ok := err.(*models.CSVHeaderError); !ok {
+		t.Error("CSV header drift not detected")
+	}
+
+	xmlData, err = readFixture("vehicles.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvData, err = readFixture("vehicles.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vehiclesFromXml, err := models.DecodeRawVehicles(xmlData)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	vehiclesFromCsv, err := models.DecodeRawVehicles(csvData)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(vehiclesFromCsv) != 1 || !reflect.DeepEqual(vehiclesFromXml, vehiclesFromCsv) {
+		t.Error("CSV vehicles decoded differently from XML")
+	}
+
+	// A column EPA has added is drift too
+	drifted = bytes.Replace(csvData, []byte(",baseModel\n"), []byte(",baseModel,newColumn\n"), 1)
+	_, err = models.DecodeRawVehicles(drifted)
+	if _, ok := err.(*models.CSVHeaderError); !ok {
+		t.Error("CSV vehicles header drift not detected")
+	}
+}
+
+// EPA's element is atvType, as in the fixture, which the atvtype tag RawVehicle
+// had before CSV decoding never matched
+func TestDecodeAtvType(t *testing.T) {
+	xmlData, err := readFixture("vehicles.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlData = bytes.Replace(xmlData, []byte("<atvType />"), []byte("<atvType>Hybrid</atvType>"), 1)
+	rvs, err := models.DecodeRawVehicles(xmlData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rvs) != 1 || rvs[0].AtvType != "Hybrid" {
+		t.Fatalf("Vehicle atvType not decoded: %+v", rvs)
+	}
+	v, err := models.NewVehicleFromRaw(&rvs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AtvType != "Hybrid" {
+		t.Errorf("Vehicle atvType not carried over: %q", v.AtvType)
+	}
+}
+
+func TestJobQuality(t *testing.T) {
+	work := workers.WorkRequest{
+		Target:  "vehicles",
+		Fetcher: testVehiclesFetcher{},
+		Action:  workers.IngestVehicles}
+	err := work.DoWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jobQualityUrl = fmt.Sprintf("%s/jobs/%d/quality", testServer.URL, work.Job.ID)
+	resp, err := doWithKey("GET", jobQualityUrl, testIngestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Job quality not a 200")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var qr handlers.QualityResponse
+	err = json.Unmarshal(body, &qr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if qr.Job.Status != models.JobSucceeded {
+		t.Error("Job quality job not succeeded")
+	}
+	if len(qr.Reports) != 2 || qr.Reports[0].Checked != 1 || qr.Reports[0].Violating != 0 {
+		t.Error("Job quality reports wrong")
+	}
+
+	// Any share of violations is over a negative threshold
+	threshold := global.QualityAbortThreshold
+	global.QualityAbortThreshold = -1.0
+	defer func() { global.QualityAbortThreshold = threshold }()
+	work = workers.WorkRequest{
+		Target:  "vehicles",
+		Fetcher: testVehiclesFetcher{},
+		Action:  workers.IngestVehicles}
+	err = work.DoWork()
+	if err == nil || work.Job.Status != models.JobFailed {
+		t.Error("Ingest over the quality threshold not aborted")
+	}
+	global.QualityAbortThreshold = threshold
+
+	// Vehicles aren't written when their emissions release is bad
+	seen, err := global.Db.SelectStrings("SELECT last_seen_in_feed FROM vehicles WHERE epa_id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	work = workers.WorkRequest{
+		Target:  "vehicles",
+		Fetcher: testBadEmissionsFetcher{},
+		Action:  workers.IngestVehicles}
+	err = work.DoWork()
+	if err == nil || work.Job.Status != models.JobFailed {
+		t.Error("Ingest with a bad emissions release not aborted")
+	}
+	after, err := global.Db.SelectStrings("SELECT last_seen_in_feed FROM vehicles WHERE epa_id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || len(after) != 1 || seen[0] != after[0] {
+		t.Errorf("Vehicles written before their emissions release was checked: %v, %v", seen, after)
+	}
+}
+
+func TestDryRunApproval(t *testing.T) {
+	// Seen in a feed long enough ago that only withdrawal's own time keeps it
+	// within the grace period once the release removes it
+	longAgo := time.Now().UTC().Add(-2 * global.WithdrawnGracePeriod)
+	_, err := global.Db.InsertOne("vehicles", &models.Vehicle{EpaID: 900051, Status: models.VehicleActive,
+		Year: 2015, Make: "Toyota", Model: "Camry", LastSeenInFeed: longAgo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer global.Db.Exec("DELETE FROM vehicles WHERE epa_id = 900051")
+	_, err = global.Db.Exec(fmt.Sprintf("UPDATE vehicles SET last_seen_in_feed = %s WHERE epa_id = 1",
+		global.Db.Dialect.Placeholder(1)), longAgo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work, err := workers.GenerateWorkRequest(global.Db, "vehicles", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work.Fetcher = testVehiclesFetcher{}
+	err = work.DoWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work.Job.Status != models.JobAwaitingApproval {
+		t.Error("Dry run not awaiting approval")
+	}
+
+	// The fixtures are already ingested so nothing is added, and only the
+	// vehicle missing from them is removed
+	var jobDiffUrl = fmt.Sprintf("%s/jobs/%d/diff", testServer.URL, work.Job.ID)
+	resp, err := doWithKey("GET", jobDiffUrl, testIngestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Job diff not a 200")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var dr handlers.DiffResponse
+	err = json.Unmarshal(body, &dr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	for dataset, counts := range dr.Summary {
+		removed := 0
+		if dataset == "vehicles" {
+			removed = 1
+		}
+		if counts[models.ChangeAdded] != 0 || counts[models.ChangeRemoved] != removed {
+			t.Errorf("Dry run of ingested %s wrong: %v", dataset, counts)
+		}
+	}
+
+	// Not while an ingest is waiting to run, which the dry run didn't see
+	queued, err := workers.NewJob(global.Db, "vehicles", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = workers.GenerateApprovalWorkRequest(global.Db, work.Job.ID)
+	if err != workers.ErrIngestPending {
+		t.Errorf("Dry run approved with an ingest queued: %v", err)
+	}
+	_, err = global.Db.Exec(fmt.Sprintf("UPDATE jobs SET status = '%s' WHERE id = %d", models.JobFailed, queued.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	approval, err := workers.GenerateApprovalWorkRequest(global.Db, work.Job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = approval.DoWork()
+	if err != nil || approval.Job.ApprovesID != work.Job.ID {
+		t.Error("Dry run approval failed")
+	}
+
+	// Unchanged vehicles in the release are seen, and the removed one is
+	// withdrawn rather than deleted straight away
+	approvedAt := time.Now().UTC().Add(-time.Minute)
+	for _, c := range []struct {
+		epaID  int
+		status string
+	}{{1, models.VehicleActive}, {900051, models.VehicleWithdrawn}} {
+		v := models.Vehicle{}
+		err = global.Db.SelectOne(&v, fmt.Sprintf("SELECT * FROM vehicles WHERE epa_id = %d", c.epaID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Status != c.status {
+			t.Errorf("Vehicle %d %s after approval, not %s", c.epaID, v.Status, c.status)
+		}
+		if c.status == models.VehicleActive && v.LastSeenInFeed.Before(approvedAt) {
+			t.Errorf("Vehicle %d in the approved release not seen: %v", c.epaID, v.LastSeenInFeed)
+		}
+		if c.status == models.VehicleWithdrawn && v.WithdrawnAt.Before(approvedAt) {
+			t.Errorf("Vehicle %d removed by the approved release not withdrawn then: %v", c.epaID, v.WithdrawnAt)
+		}
+	}
+
+	// A dry run is only applied once
+	var jobApproveUrl = fmt.Sprintf("%s/jobs/%d/approve", testServer.URL, work.Job.ID)
+	resp, err = doWithKey("POST", jobApproveUrl, testIngestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Error("Second dry run approval not a 409")
+	}
+}
+
+func TestDryRunTruncatedFeed(t *testing.T) {
+	// The one vehicle in the release is under half of those active
+	for _, epaID := range []int{900061, 900062} {
+		_, err := global.Db.InsertOne("vehicles", &models.Vehicle{EpaID: epaID, Status: models.VehicleActive,
+			Year: 2015, Make: "Toyota", Model: "Camry"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer global.Db.Exec("DELETE FROM vehicles WHERE epa_id BETWEEN 900061 AND 900062")
+
+	work, err := workers.GenerateWorkRequest(global.Db, "vehicles", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work.Fetcher = testVehiclesFetcher{}
+	err = work.DoWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := global.Db.SelectInt(fmt.Sprintf(
+		"SELECT COUNT(*) FROM ingest_changes WHERE job_id = %d AND change_type = '%s'",
+		work.Job.ID, models.ChangeRemoved))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 0 {
+		t.Errorf("Dry run of a truncated feed removed %d vehicles", removed)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var ingestUrl = fmt.Sprintf("%s/ingest/stats", testServer.URL)
+	resp, err := doWithKey("POST", ingestUrl, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") != "Bearer" {
+		t.Error("Ingest without a key not a 401")
+	}
+
+	resp, err = doWithKey("POST", ingestUrl, "fe_notakey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Error("Ingest with an unknown key not a 401")
+	}
+
+	resp, err = doWithKey("POST", ingestUrl, testReadKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("Ingest with a read key not a 403")
+	}
+
+	resp, err = doWithKey("GET", ingestUrl, testIngestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Error("Ingest with GET not a 405")
+	}
+
+	audited, err := global.Db.SelectInt("SELECT COUNT(*) FROM audit_log WHERE action = 'ingest'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = doWithKey("POST", ingestUrl, testIngestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Ingest with an ingest key not a 200")
+	}
+	count, err := global.Db.SelectInt("SELECT COUNT(*) FROM audit_log WHERE action = 'ingest'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != audited+1 {
+		t.Error("Ingest not audited")
+	}
+}
+
+func TestAdminKeys(t *testing.T) {
+	var keysUrl = fmt.Sprintf("%s/admin/keys", testServer.URL)
+	resp, err := doWithKey("GET", keysUrl, testIngestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("Keys with an ingest key not a 403")
+	}
+
+	body := bytes.NewBufferString(`{"name": "test created", "scopes": ["read"], "plan": "nope"}`)
+	req, _ := http.NewRequest("POST", keysUrl, body)
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("Key with an unknown plan not a 400")
+	}
+
+	body = bytes.NewBufferString(`{"name": "test created", "scopes": ["read"]}`)
+	req, _ = http.NewRequest("POST", keysUrl, body)
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatal("Key create not a 201")
+	}
+	defer resp.Body.Close()
+	var kr handlers.KeyResponse
+	err = json.NewDecoder(resp.Body).Decode(&kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kr.Key == "" || kr.APIKey.Plan != models.PlanDefault || kr.APIKey.Prefix != kr.Key[:models.APIKeyPrefixLength] {
+		t.Error("Key create response wrong")
+	}
+
+	resp, err = doWithKey("GET", fmt.Sprintf("%s/makes", testServer.URL), kr.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Request with a created key not a 200")
+	}
+
+	var keyUrl = fmt.Sprintf("%s/admin/keys/%d", testServer.URL, kr.APIKey.ID)
+	resp, err = doWithKey("GET", keyUrl+"/usage", testAdminKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ur handlers.UsageResponse
+	err = json.NewDecoder(resp.Body).Decode(&ur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ur.Usage) != 1 || ur.Usage[0].Count != 1 {
+		t.Error("Key usage not counted")
+	}
+
+	resp, err = doWithKey("DELETE", keyUrl, testAdminKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Error("Key revoke not a 200")
+	}
+	resp, err = doWithKey("GET", fmt.Sprintf("%s/makes", testServer.URL), kr.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Error("Request with a revoked key not a 401")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	key, _, err := auth.CreateKey("test limited", []string{models.ScopeRead}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var makesUrl = fmt.Sprintf("%s/makes", testServer.URL)
+	for i := 1; i <= 2; i++ {
+		resp, err := doWithKey("GET", makesUrl, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 200 {
+			t.Errorf("Request %d within the burst not a 200", i)
+		}
+		if resp.Header.Get("X-RateLimit-Limit") != "2" || resp.Header.Get("X-RateLimit-Remaining") != fmt.Sprint(2-i) {
+			t.Errorf("Request %d rate limit headers wrong", i)
+		}
+	}
+
+	resp, err := doWithKey("GET", makesUrl, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Error("Request over the limit not a 429")
+	}
+
+	// Unknown keys spend the address's anonymous tokens, so guessing is limited
+	anonymous := global.RateLimitPlans[models.PlanAnonymous]
+	global.RateLimitPlans[models.PlanAnonymous] = models.RateLimitPlan{RequestsPerMinute: 1, Burst: 2}
+	defer func() {
+		global.RateLimitPlans[models.PlanAnonymous] = anonymous
+		ratelimit.Limiter = ratelimit.NewMemoryStore()
+	}()
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		resp, err := doWithKey("GET", makesUrl, "guessed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Request %d with an unknown key a %d, not %d", i+1, resp.StatusCode, want)
+		}
+	}
+
+	// Prometheus scrapes from the same address without a key
+	resp, err = http.Get(fmt.Sprintf("%s/metrics", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Error("Anonymous metrics request rate limited")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	resp, err := http.Get(fmt.Sprintf("%s/makes", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(fmt.Sprintf("%s/metrics", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`fueleconomy_http_requests_total{method="GET",route="/makes",status="200"}`,
+		`fueleconomy_http_request_duration_seconds_count{method="GET",route="/makes",status="200"}`,
+		`fueleconomy_jobs_total{outcome="succeeded",target="vehicles"}`,
+		`fueleconomy_rows_upserted_total{table="vehicles"}`,
+		`fueleconomy_db_operation_duration_seconds_count{operation="SelectMany",outcome="ok"}`,
+		`go_sql_idle_connections{db_name="fueleconomy"}`,
+		`fueleconomy_workqueue_depth`,
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("Metrics missing %s", want)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(exporter, 1)
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	traceID, parentID := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/vehicles?year=1985&pageLength=3", testServer.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-01", traceID, parentID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	work := workers.WorkRequest{
+		Target:  "fuelprices",
+		Fetcher: testFuelPricesFetcher{},
+		Action:  workers.IngestFuelPrices}
+	err = work.DoWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.ForceFlush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		if _, ok := spans[span.Name]; !ok {
+			spans[span.Name] = span
+		}
+	}
+	attr := func(span tracetest.SpanStub, key string) attribute.Value {
+		for _, kv := range span.Attributes {
+			if string(kv.Key) == key {
+				return kv.Value
+			}
+		}
+		return attribute.Value{}
+	}
+
+	server, ok := spans["GET /vehicles"]
+	if !ok || server.SpanContext.TraceID().String() != traceID || server.Parent.SpanID().String() != parentID ||
+		server.SpanKind != trace.SpanKindServer {
+		t.Fatalf("Request span didn't continue the incoming trace: %+v", server)
+	}
+	if attr(server, "http.status_code").AsInt64() != http.StatusOK {
+		t.Error("Request span status code wrong")
+	}
+	count, ok := spans["SelectInt"]
+	if !ok || count.Parent.SpanID() != server.SpanContext.SpanID() ||
+		count.SpanContext.TraceID().String() != traceID {
+		t.Error("Count query not traced under the request")
+	} else if statement := attr(count, "db.statement").AsString(); statement == "" ||
+		strings.Contains(statement, "1985") {
+		t.Errorf("Count query statement not sanitised: %q", statement)
+	}
+	if fuel, ok := spans["calculate fuel data"]; !ok || fuel.Parent.SpanID() != server.SpanContext.SpanID() ||
+		attr(fuel, "vehicles").AsInt64() != 1 {
+		t.Errorf("Fuel calculations not traced under the request: %+v", fuel)
+	}
+
+	job, ok := spans["job fuelprices"]
+	if !ok || job.Parent.IsValid() || attr(job, "job.id").AsInt64() != int64(work.Job.ID) {
+		t.Fatalf("Job not traced: %+v", job)
+	}
+	if fetch, ok := spans["fetch https://www.fueleconomy.gov/ws/rest/fuelprices"]; !ok ||
+		fetch.Parent.SpanID() != job.SpanContext.SpanID() || fetch.SpanContext.TraceID() != job.SpanContext.TraceID() {
+		t.Error("Fetch not traced under its job")
+	}
+}
+
+func TestEmissionsInfoOrphans(t *testing.T) {
+	work, err := workers.GenerateWorkRequest(global.Db, "emissions", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work.Fetcher = testOrphansFetcher{}
+	err = work.DoWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := global.Db.SelectInt("SELECT COUNT(*) FROM emissions_info_orphans WHERE epa_id = 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orphans != 1 {
+		t.Error("Emissions info for missing vehicle not kept as an orphan")
+	}
+	linked, err := global.Db.SelectInt("SELECT COUNT(*) FROM emissions_info WHERE epa_id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked != 2 {
+		t.Error("Emissions info for known vehicle not inserted")
+	}
+
+	// A later release replaces the orphans
+	err = workers.IngestVehicles(testVehiclesFetcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans, err = global.Db.SelectInt("SELECT COUNT(*) FROM emissions_info_orphans")
+	if err != nil || orphans != 0 {
+		t.Error("Emissions info orphans not replaced")
+	}
+}
+
+type flatAutocompleteResponse struct {
+	Suggestions []models.Suggestion `json:"suggestions"`
+}
+
+func TestAutocomplete(t *testing.T) {
+	var autocompleteUrl = fmt.Sprintf("%s/autocomplete?q=alfa+romeo+sp", testServer.URL)
+	req, err := http.NewRequest("GET", autocompleteUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Autocomplete not a 200")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var fa flatAutocompleteResponse
+	err = json.Unmarshal(body, &fa)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if len(fa.Suggestions) != 1 {
+		t.Error("Autocomplete returned wrong number of suggestions")
+	}
+
+	if fa.Suggestions[0].Text != "Alfa Romeo Spider Veloce 2000" {
+		t.Error("Autocomplete suggestion text incorrect")
+	}
+
+	// Wildcards in the prefix match only themselves
+	for _, q := range []string{"%25", "_", "alfa%25", "a%5C"} {
+		resp, err = http.Get(fmt.Sprintf("%s/autocomplete?q=%s", testServer.URL, q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err = ioutil.ReadAll(resp.Body)
+		fa = flatAutocompleteResponse{}
+		err = json.Unmarshal(body, &fa)
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if resp.StatusCode != 200 || len(fa.Suggestions) != 0 {
+			t.Errorf("Autocomplete of %s matched as a wildcard: %d %+v", q, resp.StatusCode, fa.Suggestions)
+		}
+	}
+}
+
+func TestCatalogEmpty(t *testing.T) {
+	resp, err := http.Get(fmt.Sprintf("%s/makes?year=1900", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || !bytes.Contains(body, []byte(`"makes":[]`)) {
+		t.Errorf("Catalog makes for a year with none not an empty list: %s", body)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, err := global.Db.InsertMany("vehicles",
+		&models.Vehicle{EpaID: 900011, Status: models.VehicleActive, Year: 2015, Make: "Toyota",
+			SizeClass: "Midsize Cars", F1Co2Tailpipe: 300, F1MpgCity: 30, F1MpgHighway: 30, F1MpgComb: 30},
+		&models.Vehicle{EpaID: 900012, Status: models.VehicleActive, Year: 2015, Make: "Toyota",
+			SizeClass: "Midsize Cars", F1Co2Tailpipe: 400, F1MpgCity: 25, F1MpgHighway: 25, F1MpgComb: 25},
+		&models.Vehicle{EpaID: 900013, Status: models.VehicleActive, Year: 2016, Make: "Toyota",
+			SizeClass: "Compact Cars", F1Co2Tailpipe: 500},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		global.Db.Conn.Exec("DELETE FROM vehicles WHERE epa_id BETWEEN 900011 AND 900013")
+		workers.ComputeStatistics(nil)
+	}()
+	err = workers.ComputeStatistics(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statsUrl = fmt.Sprintf("%s/stats?groupBy=make&group=Toyota", testServer.URL)
+	req, err := http.NewRequest("GET", statsUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Stats not a 200")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var sr handlers.StatsResponse
+	err = json.Unmarshal(body, &sr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if sr.GroupBy != "make" || len(sr.Groups) != 1 || sr.Groups[0].Group != "Toyota" {
+		t.Fatalf("Stats by make wrong groups: %+v", sr)
+	}
+	co2 := sr.Groups[0].Metrics["co2"]
+	if co2.Count != 3 || co2.Min != 300 || co2.Max != 500 || co2.Mean != 400 || co2.Median != 400 {
+		t.Errorf("Stats co2 for Toyota wrong: %+v", co2)
+	}
+	// Vehicles without MPG data are left out of its statistics
+	if mpg := sr.Groups[0].Metrics["mpg"]; mpg.Count != 2 || mpg.Min != 25 || mpg.Max != 30 {
+		t.Errorf("Stats mpg for Toyota wrong: %+v", mpg)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/stats?metric=co2", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	sr = handlers.StatsResponse{}
+	err = json.Unmarshal(body, &sr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if sr.GroupBy != handlers.StatsGroupByDefault {
+		t.Errorf("Stats not grouped by %s by default", handlers.StatsGroupByDefault)
+	}
+	years := make(map[string]int)
+	for _, group := range sr.Groups {
+		if len(group.Metrics) != 1 {
+			t.Errorf("Stats for %s not narrowed to co2: %+v", group.Group, group.Metrics)
+		}
+		years[group.Group] = group.Metrics["co2"].Count
+	}
+	if years["2015"] != 2 || years["2016"] != 1 {
+		t.Errorf("Stats co2 counts by year wrong: %v", years)
+	}
+
+	for _, query := range []string{"groupBy=colour", "metric=topSpeed"} {
+		resp, err = http.Get(fmt.Sprintf("%s/stats?%s", testServer.URL, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Stats with %s not a 400", query)
+		}
+	}
+}
+
+func TestTrends(t *testing.T) {
+	_, err := global.Db.InsertMany("vehicles",
+		&models.Vehicle{EpaID: 900021, Status: models.VehicleActive, Year: 2015, Make: "Toyota",
+			Model: "Camry", F1MpgComb: 28, F1Co2Tailpipe: 320},
+		&models.Vehicle{EpaID: 900022, Status: models.VehicleActive, Year: 2016, Make: "Toyota",
+			Model: "Camry", F1MpgComb: 30, F1Co2Tailpipe: 300},
+		&models.Vehicle{EpaID: 900023, Status: models.VehicleActive, Year: 2016, Make: "Toyota",
+			Model: "Camry Hybrid", AtvType: "Hybrid", F1MpgComb: 40, F1Co2Tailpipe: 220},
+		&models.Vehicle{EpaID: 900024, Status: models.VehicleActive, Year: 2015, Make: "Toyota",
+			Model: "Camry Solara", F1MpgComb: 24},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer global.Db.Conn.Exec("DELETE FROM vehicles WHERE epa_id BETWEEN 900021 AND 900024")
+
+	var trendsUrl = fmt.Sprintf("%s/trends?make=Toyota&model=Camry", testServer.URL)
+	req, err := http.NewRequest("GET", trendsUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Trends not a 200")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var tr handlers.TrendsResponse
+	err = json.Unmarshal(body, &tr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// A nameplate's series leaves out models that only share its prefix
+	if tr.Make != "Toyota" || tr.Model != "Camry" || len(tr.Series) != 2 {
+		t.Fatalf("Trends for the Camry wrong: %+v", tr)
+	}
+	for i, want := range []models.TrendPoint{
+		{Year: 2015, Count: 1, BestMpg: 28, WorstMpg: 28, AvgMpg: 28},
+		{Year: 2016, Count: 1, BestMpg: 30, WorstMpg: 30, AvgMpg: 30},
+	} {
+		point := tr.Series[i]
+		if point.Year != want.Year || point.Count != want.Count || point.BestMpg != want.BestMpg ||
+			point.WorstMpg != want.WorstMpg || point.AvgMpg != want.AvgMpg || point.ElectrifiedShare != 0 {
+			t.Errorf("Trends point for %d wrong: %+v", want.Year, point)
+		}
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/trends?make=Toyota&from=2016", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	tr = handlers.TrendsResponse{}
+	err = json.Unmarshal(body, &tr)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if len(tr.Series) != 1 || tr.Series[0].Year != 2016 || tr.Series[0].Count != 2 ||
+		tr.Series[0].BestMpg != 40 || tr.Series[0].BestCo2 != 220 || tr.Series[0].ElectrifiedShare != 0.5 {
+		t.Errorf("Trends for Toyota from 2016 wrong: %+v", tr.Series)
+	}
+}
+
+func TestVehicleGetAlternatives(t *testing.T) {
+	compact := func(epaID int, year int, sizeClass string, co2 float64) *models.Vehicle {
+		return &models.Vehicle{EpaID: epaID, Status: models.VehicleActive, Year: year, Make: "Toyota", SizeClass: sizeClass,
+			DriveAxleType: "Front-Wheel Drive", F1Co2Tailpipe: co2}
+	}
+	target := compact(900031, 2015, "Compact Cars", 400)
+	target.PassengerVolume4Door = 90
+	comparable := compact(900032, 2015, "Compact Cars", 300)
+	comparable.PassengerVolume4Door = 95
+	roomier := compact(900036, 2015, "Compact Cars", 200)
+	roomier.PassengerVolume4Door = 120
+	awd := compact(900037, 2016, "Compact Cars", 200)
+	awd.DriveAxleType = "4-Wheel Drive"
+	_, err := global.Db.InsertMany("vehicles",
+		target,
+		comparable,
+		compact(900033, 2016, "Midsize Cars", 250),
+		compact(900034, 2015, "Large Cars", 200),
+		compact(900035, 2014, "Compact Cars", 450),
+		roomier,
+		awd,
+		compact(900038, 2001, "Special Purpose Vehicles", 400),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer global.Db.Conn.Exec("DELETE FROM vehicles WHERE epa_id BETWEEN 900031 AND 900038")
+
+	var alternativesUrl = fmt.Sprintf("%s/vehicle/900031/alternatives?rankBy=co2", testServer.URL)
+	req, err := http.NewRequest("GET", alternativesUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Vehicle get alternatives not a 200")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	var ar handlers.AlternativesResponse
+	err = json.Unmarshal(body, &ar)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	// Ranked by CO2 saved, leaving out a size class two rungs up, a greater
+	// passenger volume, another drive and anything emitting more
+	if ar.Vehicle.EpaID != 900031 || ar.RankBy != "co2" || len(ar.Alternatives) != 2 {
+		t.Fatalf("Alternatives for 900031 wrong: %+v", ar)
+	}
+	for i, want := range []struct {
+		epaID   int
+		saved   float64
+		reasons []string
+	}{
+		{900033, 2250, []string{
+			"Adjacent size class (Midsize Cars)",
+			"Adjacent model year (2016)",
+			"Comparable drive (Front-Wheel Drive)",
+			"Emits 2250 kg less tailpipe CO2 per year",
+		}},
+		{900032, 1500, []string{
+			"Same size class (Compact Cars)",
+			"Same model year (2015)",
+			"Comparable drive (Front-Wheel Drive)",
+			"Comparable passenger volume (95 vs 90 cubic feet)",
+			"Emits 1500 kg less tailpipe CO2 per year",
+		}},
+	} {
+		alt := ar.Alternatives[i]
+		if alt.Vehicle.EpaID != want.epaID || alt.AnnualCo2SavedKg != want.saved {
+			t.Errorf("Alternative %d wrong: %d saving %v kg", i, alt.Vehicle.EpaID, alt.AnnualCo2SavedKg)
+		}
+		if !reflect.DeepEqual(alt.Reasons, want.reasons) {
+			t.Errorf("Alternative %d reasons wrong: %v", want.epaID, alt.Reasons)
+		}
+	}
+
+	// Emitting more is only an alternative when asked for
+	resp, err = http.Get(fmt.Sprintf("%s/vehicle/900031/alternatives?rankBy=co2&greenerOnly=false", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	ar = handlers.AlternativesResponse{}
+	err = json.Unmarshal(body, &ar)
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	if len(ar.Alternatives) != 3 || ar.Alternatives[2].Vehicle.EpaID != 900035 ||
+		ar.Alternatives[2].AnnualCo2SavedKg != -750 {
+		t.Errorf("Alternatives including greater emitters wrong: %+v", ar.Alternatives)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/vehicle/900038/alternatives", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != 200 || !strings.Contains(string(body), `"alternatives":[]`) {
+		t.Errorf("Vehicle without candidates didn't get an empty list: %s", body)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/vehicle/900031/alternatives?rankBy=mpg", testServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("Vehicle get alternatives with unsupported rankBy not a 400")
+	}
+}
+
+func TestVehicleExport(t *testing.T) {
+	var vehicleExportUrl = fmt.Sprintf("%s/vehicles/export?year=1985", testServer.URL)
+	req, err := http.NewRequest("GET", vehicleExportUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Error("Vehicle export not a 200")
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	// CSV by default, a header then the one 1985 vehicle
+	if resp.Header.Get("Content-Type") != "text/csv" ||
+		resp.Header.Get("Content-Disposition") != `attachment; filename="vehicles.csv"` {
+		t.Errorf("Export not a CSV attachment: %v", resp.Header)
+	}
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !reflect.DeepEqual(records[0], models.FlatColumns()) {
+		t.Fatalf("Export CSV wrong rows: %v", records)
+	}
+	row := make(map[string]string)
+	for i, column := range records[0] {
+		row[column] = records[1][i]
+	}
+	if row["epa_id"] != "1" || row["make"] != "Alfa Romeo" || row["year"] != "1985" || row["fuel2_fuel_type"] != "" {
+		t.Errorf("Export CSV row wrong: %v", row)
+	}
+
+	// The format parameter and Accept header pick NDJSON
+	for _, accept := range []string{"", "application/x-ndjson"} {
+		url := vehicleExportUrl + "&format=ndjson"
+		if accept != "" {
+			url = vehicleExportUrl
+		}
+		req, err = http.NewRequest("GET", url, nil)
+		req.Header.Set("Accept", accept)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err = ioutil.ReadAll(resp.Body)
+
+		if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("Export with Accept %q not NDJSON: %s", accept, resp.Header.Get("Content-Type"))
+		}
+		lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+		if len(lines) != 1 {
+			t.Fatalf("Export NDJSON wrong lines: %s", body)
+		}
+		var obj map[string]interface{}
+		err = json.Unmarshal(lines[0], &obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj["epa_id"] != 1.0 || obj["make"] != "Alfa Romeo" || obj["model"] != "Spider Veloce 2000" {
+			t.Errorf("Export NDJSON row wrong: %v", obj)
+		}
+	}
+
+	// XLSX is a zip with the rows in its one sheet
+	resp, err = http.Get(vehicleExportUrl + "&format=xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+
+	if resp.Header.Get("Content-Disposition") != `attachment; filename="vehicles.xlsx"` {
+		t.Errorf("Export not an XLSX attachment: %v", resp.Header)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheet []byte
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bytes.Count(sheet, []byte("<row>")) != 2 ||
+		!bytes.Contains(sheet, []byte(`<c t="inlineStr"><is><t xml:space="preserve">epa_id</t></is></c>`)) ||
+		!bytes.Contains(sheet, []byte(`<c t="inlineStr"><is><t xml:space="preserve">Alfa Romeo</t></is></c>`)) ||
+		!bytes.Contains(sheet, []byte(`<c><v>1985</v></c>`)) {
+		t.Errorf("Export XLSX sheet wrong: %s", sheet)
+	}
+
+	// A page of /vehicles is exported when asked for, and JSON otherwise
+	for _, c := range []struct {
+		query, accept, contentType string
+	}{
+		{"year=1985&format=csv", "", "text/csv"},
+		{"year=1985", "text/csv", "text/csv"},
+		{"year=1985", "application/json, text/csv", "application/json"},
+		{"year=1985&format=json", "text/csv", "application/json"},
+		{"year=1985&format=xlsx", "", export.Formats["xlsx"].ContentType},
+	} {
+		req, err = http.NewRequest("GET", fmt.Sprintf("%s/vehicles?%s", testServer.URL, c.query), nil)
+		req.Header.Set("Accept", c.accept)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err = ioutil.ReadAll(resp.Body)
+
+		if resp.Header.Get("Content-Type") != c.contentType {
+			t.Errorf("/vehicles?%s with Accept %q not %s: %s", c.query, c.accept, c.contentType,
+				resp.Header.Get("Content-Type"))
+		}
+		if c.contentType == "text/csv" && !bytes.HasPrefix(body, []byte(models.FlatColumns()[0]+",")) {
+			t.Errorf("/vehicles?%s CSV doesn't start with its header: %s", c.query, body)
+		}
+	}
+
+	for _, path := range []string{"/vehicles/export", "/vehicles"} {
+		resp, err = http.Get(fmt.Sprintf("%s%s?format=pdf", testServer.URL, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s with unsupported format not a 400", path)
+		}
+	}
+}
+
+// Setup
+func setup() (err error) {
+	workRequest := workers.WorkRequest{
+		Target:  "fuelprices",
+		Fetcher: testFuelPricesFetcher{},
+		Action:  workers.IngestFuelPrices}
+	err = workRequest.DoWork()
+	if err != nil {
+		return err
+	}
+
+	workRequest = workers.WorkRequest{
+		Target:  "vehicles",
+		Fetcher: testVehiclesFetcher{},
+		Action:  workers.IngestVehicles}
+	err = workRequest.DoWork()
+	if err != nil {
+		return err
+	}
+
+	testAdminKey, _, err = auth.CreateKey("test admin", []string{models.ScopeAdmin}, "")
+	if err != nil {
+		return err
+	}
+	testIngestKey, _, err = auth.CreateKey("test ingest", []string{models.ScopeIngest}, "")
+	if err != nil {
+		return err
+	}
+	testReadKey, _, err = auth.CreateKey("test read", []string{models.ScopeRead}, "")
 	if err != nil {
 		return err
 	}
@@ -231,8 +2238,12 @@ func TestMain(m *testing.M) {
 		os.Exit(1)
 	}
 
-	global.InitLogger(new(DevNull))
+	global.InitLogger(new(DevNull), "info", "json")
 	global.InitDb("sqlite3", SQLITE_DB)
+	// Every test request is anonymous or made with a test key
+	global.RateLimitPlans[models.PlanAnonymous] = models.RateLimitPlan{RequestsPerMinute: 60000, Burst: 10000}
+	global.RateLimitPlans[models.PlanDefault] = models.RateLimitPlan{RequestsPerMinute: 60000, Burst: 10000}
+	global.RateLimitPlans["test"] = models.RateLimitPlan{RequestsPerMinute: 1, Burst: 2}
 	testServer = httptest.NewServer(handlers.NewRouter())
 
 	err = setup()
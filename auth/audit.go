@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+// Records an action taken with a key through db, so it can share a transaction
+// with the action. jobID is zero if no job was queued.
+func Audit(db *srm.DbMap, apiKey *models.APIKey, action string, target string, jobID int,
+	remoteAddr string) error {
+	entry := &models.AuditEntry{
+		KeyID:      apiKey.ID,
+		KeyName:    apiKey.Name,
+		Action:     action,
+		Target:     target,
+		JobID:      jobID,
+		RemoteAddr: remoteAddr,
+		CreatedAt:  time.Now().UTC(),
+	}
+	_, err := db.InsertOne("audit_log", entry)
+	if err != nil {
+		return err
+	}
+	global.Logger.Info("Audit", "key_id", apiKey.ID, "key_name", apiKey.Name, "action", action,
+		"target", target, "job_id", jobID, "remote_addr", remoteAddr)
+	return nil
+}
+
+// A page of the audit log, newest first, with the total number of entries
+func GetAuditLog(limit int, offset int) ([]models.AuditEntry, int, error) {
+	entries := make([]models.AuditEntry, 0)
+	total, err := global.Db.SelectInt("SELECT COUNT(*) FROM audit_log")
+	if err != nil {
+		return entries, 0, err
+	}
+	p := global.Db.Dialect.Placeholder
+	query := fmt.Sprintf("SELECT * FROM audit_log ORDER BY id DESC LIMIT %s OFFSET %s", p(1), p(2))
+	err = global.Db.SelectMany(&entries, query, limit, offset)
+	return entries, total, err
+}
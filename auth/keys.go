@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+var ErrInvalidKey error = errors.New("Invalid or revoked API key")
+
+// Issues a key with the given scopes and rate limit plan, returning the key
+// itself, which isn't stored and can't be recovered
+func CreateKey(name string, scopes []string, plan string) (string, *models.APIKey, error) {
+	if plan == "" {
+		plan = models.PlanDefault
+	}
+	err := ValidateKey(name, scopes, plan)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, apiKey, err := models.NewAPIKey(name, scopes, plan)
+	if err != nil {
+		return "", nil, err
+	}
+	apiKey.ID, err = global.Db.InsertOne("api_keys", apiKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+// Checks a key to be issued has a name, known scopes and a plan keys can be on
+func ValidateKey(name string, scopes []string, plan string) error {
+	if name == "" {
+		return errors.New("API keys need a name")
+	}
+	if len(scopes) == 0 {
+		return errors.New("API keys need at least one scope")
+	}
+	for _, scope := range scopes {
+		if !models.IsScope(scope) {
+			return errors.New(fmt.Sprintf("Unknown scope %s, expected one of %s",
+				scope, strings.Join(models.Scopes, ", ")))
+		}
+	}
+	if _, ok := global.RateLimitPlans[plan]; !ok || plan == models.PlanAnonymous {
+		return errors.New(fmt.Sprintf("Unknown plan %s", plan))
+	}
+	return nil
+}
+
+// Returns ErrInvalidKey if the key isn't known or was revoked
+func LookupKey(key string) (*models.APIKey, error) {
+	apiKey := &models.APIKey{}
+	query := fmt.Sprintf("SELECT * FROM api_keys WHERE hash = %s", global.Db.Dialect.Placeholder(1))
+	err := global.Db.SelectOne(apiKey, query, models.HashAPIKey(key))
+	if srm.ErrorKind(err) == srm.ErrNotFound {
+		return nil, ErrInvalidKey
+	} else if err != nil {
+		return nil, err
+	}
+	if apiKey.Revoked {
+		return nil, ErrInvalidKey
+	}
+	apiKey.FillScopes()
+	return apiKey, nil
+}
+
+// A page of keys, oldest first, with the total number of keys
+func GetKeys(limit int, offset int) ([]models.APIKey, int, error) {
+	keys := make([]models.APIKey, 0)
+	total, err := global.Db.SelectInt("SELECT COUNT(*) FROM api_keys")
+	if err != nil {
+		return keys, 0, err
+	}
+	p := global.Db.Dialect.Placeholder
+	query := fmt.Sprintf("SELECT * FROM api_keys ORDER BY id LIMIT %s OFFSET %s", p(1), p(2))
+	err = global.Db.SelectMany(&keys, query, limit, offset)
+	for i := range keys {
+		keys[i].FillScopes()
+	}
+	return keys, total, err
+}
+
+// Returns an srm.ErrNotFound error if there's no such key
+func GetKey(id int) (*models.APIKey, error) {
+	apiKey := &models.APIKey{}
+	query := fmt.Sprintf("SELECT * FROM api_keys WHERE id = %s", global.Db.Dialect.Placeholder(1))
+	err := global.Db.SelectOne(apiKey, query, id)
+	if err != nil {
+		return nil, err
+	}
+	apiKey.FillScopes()
+	return apiKey, nil
+}
+
+// Keys are revoked rather than deleted so the audit log can refer to them.
+// Returns an srm.ErrNotFound error if there's no such key.
+func RevokeKey(id int) (*models.APIKey, error) {
+	query := fmt.Sprintf("UPDATE api_keys SET revoked = %s WHERE id = %s",
+		global.Db.Dialect.Placeholder(1), global.Db.Dialect.Placeholder(2))
+	_, err := global.Db.Exec(query, true, id)
+	if err != nil {
+		return nil, err
+	}
+	return GetKey(id)
+}
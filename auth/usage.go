@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+)
+
+type usageKey struct {
+	keyID int
+	day   string
+}
+
+// Request counts not yet written to the usage table
+var (
+	usageMu     sync.Mutex
+	usageCounts map[usageKey]int = make(map[usageKey]int)
+)
+
+// Counts a request made with a key, 0 if anonymous. Counts are kept in
+// memory until FlushUsage adds them to the usage table.
+func RecordUsage(keyID int, at time.Time) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	usageCounts[usageKey{keyID, at.UTC().Format("2006-01-02")}]++
+}
+
+// Adds the counts recorded since the last flush to the usage table. Counts
+// that couldn't be written are kept for the next flush.
+func FlushUsage() error {
+	usageMu.Lock()
+	counts := usageCounts
+	usageCounts = make(map[usageKey]int)
+	usageMu.Unlock()
+
+	p := global.Db.Dialect.Placeholder
+	query := fmt.Sprintf(`INSERT INTO usage (key_id, day, count) VALUES (%s, %s, %s)
+		ON CONFLICT (key_id, day) DO UPDATE SET count = usage.count + excluded.count`, p(1), p(2), p(3))
+	var err error
+	for k, count := range counts {
+		_, err = global.Db.Exec(query, k.keyID, k.day, count)
+		if err != nil {
+			break
+		}
+		delete(counts, k)
+	}
+	if len(counts) > 0 {
+		usageMu.Lock()
+		for k, count := range counts {
+			usageCounts[k] += count
+		}
+		usageMu.Unlock()
+	}
+	return err
+}
+
+// Flushes usage every interval
+func StartUsageFlusher(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			err := FlushUsage()
+			if err != nil {
+				global.Logger.Error("Usage flush failed", "error", err)
+			}
+		}
+	}()
+}
+
+// A key's usage by day, most recent first
+func GetUsage(keyID int) ([]models.Usage, error) {
+	usage := make([]models.Usage, 0)
+	query := fmt.Sprintf("SELECT * FROM usage WHERE key_id = %s ORDER BY day DESC",
+		global.Db.Dialect.Placeholder(1))
+	err := global.Db.SelectMany(&usage, query, keyID)
+	return usage, err
+}
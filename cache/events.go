@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"sync"
+)
+
+// Published by workers when a job that changed the dataset succeeds
+type Invalidation struct {
+	Target string // The job's target, e.g. vehicles or fuelprices
+	JobID  int
+}
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   []func(Invalidation)
+)
+
+// Calls fn with every invalidation published from then on
+func Subscribe(fn func(Invalidation)) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers = append(subscribers, fn)
+}
+
+func Publish(inv Invalidation) {
+	subscribersMu.Lock()
+	fns := append([]func(Invalidation){}, subscribers...)
+	subscribersMu.Unlock()
+	for _, fn := range fns {
+		fn(inv)
+	}
+}
+
+// Any change to the dataset can change a vehicle response, and fuel prices
+// only change with a fuel prices ingest
+func invalidate(inv Invalidation) {
+	Responses.Clear()
+	if inv.Target == "fuelprices" {
+		fuelPrices.refresh()
+	}
+}
+
+func init() {
+	Subscribe(invalidate)
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+)
+
+// The current fuel prices, loaded on first use and again after a fuel prices
+// ingest, or once they're older than maxAge
+type fuelPricesCache struct {
+	mu     sync.Mutex
+	fp     models.FuelPrices
+	loaded time.Time
+	maxAge time.Duration
+	stats  Stats
+}
+
+var fuelPrices *fuelPricesCache = &fuelPricesCache{maxAge: 5 * time.Minute}
+
+// The most recently ingested fuel prices
+func CurrentFuelPrices() (models.FuelPrices, error) {
+	return fuelPrices.get()
+}
+
+func FuelPricesStats() Stats {
+	fuelPrices.mu.Lock()
+	defer fuelPrices.mu.Unlock()
+	return fuelPrices.stats
+}
+
+func (c *fuelPricesCache) get() (models.FuelPrices, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded.IsZero() && time.Since(c.loaded) <= c.maxAge {
+		c.stats.Hits++
+		return c.fp, nil
+	}
+	c.stats.Misses++
+
+	fp := models.FuelPrices{}
+	query := "SELECT * FROM fuel_prices WHERE updated = (SELECT MAX(updated) from fuel_prices)"
+	err := global.Db.SelectOne(&fp, query)
+	if err != nil {
+		return fp, err
+	}
+	c.fp, c.loaded = fp, time.Now()
+	c.stats.Entries = 1
+	return fp, nil
+}
+
+func (c *fuelPricesCache) refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loaded = time.Time{}
+	c.stats.Entries = 0
+	c.stats.Invalidations++
+}
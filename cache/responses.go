@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// A computed response, stored with the validators it was sent with
+type Response struct {
+	Body         []byte
+	ETag         string
+	LastModified time.Time
+}
+
+// How a cache has fared since it was made
+type Stats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`     // Entries dropped to stay within limits or expired
+	Invalidations int64 `json:"invalidations"` // Times the cache was cleared or refreshed
+	Entries       int   `json:"entries"`
+	Bytes         int   `json:"bytes"`
+	MaxEntries    int   `json:"maxEntries,omitempty"`
+	MaxBytes      int   `json:"maxBytes,omitempty"`
+}
+
+type responseEntry struct {
+	key      string
+	response Response
+	added    time.Time
+}
+
+// A least recently used cache of responses, limited by number of entries and
+// by the total size of their bodies. Entries expire after MaxAge, which
+// bounds how stale a replica can be when another replica ran the ingest.
+type ResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used at the front
+	maxAge  time.Duration
+	stats   Stats
+
+	// Incremented by Clear, so responses computed before it aren't added
+	generation int
+}
+
+func NewResponseCache(maxEntries int, maxBytes int, maxAge time.Duration) *ResponseCache {
+	return &ResponseCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		maxAge:  maxAge,
+		stats:   Stats{MaxEntries: maxEntries, MaxBytes: maxBytes},
+	}
+}
+
+// Computed vehicle responses, keyed by their normalised query, which
+// includes the vehicle ID and driving profile
+var Responses *ResponseCache = NewResponseCache(10000, 64<<20, 5*time.Minute)
+
+func (c *ResponseCache) Get(key string) (Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok && time.Since(el.Value.(*responseEntry).added) > c.maxAge {
+		c.remove(el)
+		c.stats.Evictions++
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return Response{}, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(el)
+	return el.Value.(*responseEntry).response, true
+}
+
+// Taken before computing a response to Add
+func (c *ResponseCache) Generation() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Keeps a response computed in a generation, unless the cache has been
+// cleared since or the response is larger than the cache
+func (c *ResponseCache) Add(key string, generation int, response Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation || len(response.Body) > c.stats.MaxBytes {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(&responseEntry{key, response, time.Now()})
+	c.stats.Entries++
+	c.stats.Bytes += len(response.Body)
+	c.evict()
+}
+
+// Changes the limits, evicting entries if the cache is now over them
+func (c *ResponseCache) SetLimits(maxEntries int, maxBytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.MaxEntries, c.stats.MaxBytes = maxEntries, maxBytes
+	c.evict()
+}
+
+func (c *ResponseCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.stats.Entries, c.stats.Bytes = 0, 0
+	c.stats.Invalidations++
+	c.generation++
+}
+
+func (c *ResponseCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *ResponseCache) evict() {
+	for c.stats.Entries > c.stats.MaxEntries || c.stats.Bytes > c.stats.MaxBytes {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *ResponseCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*responseEntry)
+	delete(c.entries, entry.key)
+	c.stats.Entries--
+	c.stats.Bytes -= len(entry.response.Body)
+}
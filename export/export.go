@@ -0,0 +1,121 @@
+// Package export writes rows of values as CSV, NDJSON or XLSX, one row at a
+// time so result sets can be streamed straight from a database cursor.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+type Writer interface {
+	WriteHeader(columns []string) error
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+	NewWriter   func(io.Writer) Writer
+}
+
+var Formats map[string]Format = map[string]Format{
+	"csv": Format{"csv", "text/csv", "csv",
+		func(w io.Writer) Writer { return NewCSVWriter(w) }},
+	"ndjson": Format{"ndjson", "application/x-ndjson", "ndjson",
+		func(w io.Writer) Writer { return NewNDJSONWriter(w) }},
+	"xlsx": Format{"xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx",
+		func(w io.Writer) Writer { return NewXLSXWriter(w, "data") }},
+}
+
+// Finds the format for a MIME type, if supported
+func FormatForContentType(contentType string) (Format, bool) {
+	for _, format := range Formats {
+		if format.ContentType == contentType {
+			return format, true
+		}
+	}
+	return Format{}, false
+}
+
+type CSVWriter struct {
+	w *csv.Writer
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{csv.NewWriter(w)}
+}
+
+func (c *CSVWriter) WriteHeader(columns []string) error {
+	return c.w.Write(columns)
+}
+
+func (c *CSVWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, val := range values {
+		record[i] = FormatValue(val)
+	}
+	return c.w.Write(record)
+}
+
+func (c *CSVWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// One JSON object per row, keyed by column
+type NDJSONWriter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	return &NDJSONWriter{enc: json.NewEncoder(w)}
+}
+
+func (n *NDJSONWriter) WriteHeader(columns []string) error {
+	n.columns = columns
+	return nil
+}
+
+func (n *NDJSONWriter) WriteRow(values []interface{}) error {
+	obj := make(map[string]interface{}, len(values))
+	for i, val := range values {
+		obj[n.columns[i]] = val
+	}
+	return n.enc.Encode(obj)
+}
+
+func (n *NDJSONWriter) Close() error {
+	return nil
+}
+
+// Text form of a value, empty for nil and zero times
+func FormatValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
@@ -0,0 +1,132 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const (
+	xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`
+	xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`
+	xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+	xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`
+	xlsxSheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	xlsxSheetEnd = `</sheetData></worksheet>`
+)
+
+// Minimal single sheet workbook. The sheet is the last zip entry and is
+// written as rows arrive, so nothing is buffered beyond the zip's deflate
+// window. Strings are inline rather than shared.
+type XLSXWriter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	err   error
+}
+
+func NewXLSXWriter(w io.Writer, sheetName string) *XLSXWriter {
+	x := &XLSXWriter{zw: zip.NewWriter(w)}
+	var name bytesWriter
+	xml.EscapeText(&name, []byte(sheetName))
+	parts := []struct{ path, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, string(name))},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+	}
+	for _, part := range parts {
+		f, err := x.zw.Create(part.path)
+		if err != nil {
+			x.err = err
+			return x
+		}
+		if _, err = io.WriteString(f, part.content); err != nil {
+			x.err = err
+			return x
+		}
+	}
+	f, err := x.zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		x.err = err
+		return x
+	}
+	x.sheet = bufio.NewWriter(f)
+	_, x.err = x.sheet.WriteString(xlsxSheetStart)
+	return x
+}
+
+func (x *XLSXWriter) WriteHeader(columns []string) error {
+	values := make([]interface{}, len(columns))
+	for i, col := range columns {
+		values[i] = col
+	}
+	return x.WriteRow(values)
+}
+
+func (x *XLSXWriter) WriteRow(values []interface{}) error {
+	if x.err != nil {
+		return x.err
+	}
+	x.sheet.WriteString("<row>")
+	for _, val := range values {
+		switch v := val.(type) {
+		case nil:
+			x.sheet.WriteString("<c/>")
+		case int, int64, float64:
+			x.sheet.WriteString(fmt.Sprintf("<c><v>%s</v></c>", FormatValue(v)))
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			x.sheet.WriteString(fmt.Sprintf(`<c t="b"><v>%d</v></c>`, b))
+		default:
+			x.writeInlineString(FormatValue(v))
+		}
+	}
+	_, x.err = x.sheet.WriteString("</row>")
+	return x.err
+}
+
+func (x *XLSXWriter) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+	if _, err := x.sheet.WriteString(xlsxSheetEnd); err != nil {
+		return err
+	}
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
+
+func (x *XLSXWriter) writeInlineString(s string) {
+	x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	xml.EscapeText(x.sheet, []byte(s))
+	x.sheet.WriteString("</t></is></c>")
+}
+
+type bytesWriter []byte
+
+func (b *bytesWriter) Write(p []byte) (int, error) {
+	*b = append(*b, p...)
+	return len(p), nil
+}
@@ -1,35 +1,97 @@
 package global
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
+	"github.com/teasherm/fueleconomy/logging"
+	"github.com/teasherm/fueleconomy/metrics"
+	"github.com/teasherm/fueleconomy/models"
 	"github.com/teasherm/fueleconomy/srm"
+	"github.com/teasherm/fueleconomy/tracing"
 )
 
 var (
-	Db     *srm.DbMap
-	Logger *log.Logger
+	Db      *srm.DbMap
+	Logger  *slog.Logger
+	Mirrors map[string]string
+
+	// sql-migrate migration directories, from MIGRATIONS_PATH if set
+	MigrationsDir string = getEnv("MIGRATIONS_PATH", "migrations")
+
+	// Ingests are aborted when a larger share of records break data quality
+	// rules, from the config file if set
+	QualityAbortThreshold float64 = 0.1
+
+	// How long vehicles withdrawn from the EPA feed are kept before they're
+	// soft deleted, from the config file if set
+	WithdrawnGracePeriod time.Duration = 30 * 24 * time.Hour
+
+	// Rate limits by plan name, merged with any from the config file. Keys
+	// are limited by their own plan, anonymous requests by PlanAnonymous.
+	RateLimitPlans map[string]models.RateLimitPlan = map[string]models.RateLimitPlan{
+		models.PlanAnonymous: {RequestsPerMinute: 30, Burst: 10},
+		models.PlanDefault:   {RequestsPerMinute: 120, Burst: 30},
+		"partner":            {RequestsPerMinute: 1200, Burst: 200},
+	}
+
+	// Where dataset snapshots are written, from SNAPSHOT_PATH if set
+	SnapshotDir string = getEnv("SNAPSHOT_PATH", filepath.Join(os.TempDir(), "fueleconomy_snapshots"))
 )
 
-// Holds postgres connection string
+// Holds postgres connection string, named local mirrors of the EPA data (each
+// a directory or zip archive LocalFetcher can read) and the data quality
+// abort threshold, the withdrawn vehicle grace period, rate limit plans,
+// where rate limit buckets are kept ("memory", the default, or "postgres"),
+// the response cache's limits, the log level and format, and where spans are
+// exported
 type Config struct {
-	Db string `json:"db"`
+	Db                    string                          `json:"db"`
+	Mirrors               map[string]string               `json:"mirrors"`
+	QualityAbortThreshold *float64                        `json:"qualityAbortThreshold"`
+	WithdrawnGraceDays    *int                            `json:"withdrawnGraceDays"`
+	RateLimitPlans        map[string]models.RateLimitPlan `json:"rateLimitPlans"`
+	RateLimitStore        string                          `json:"rateLimitStore"`
+	ResponseCacheEntries  *int                            `json:"responseCacheEntries"`
+	ResponseCacheBytes    *int                            `json:"responseCacheBytes"`
+	LogLevel              string                          `json:"logLevel"`
+	LogFormat             string                          `json:"logFormat"`
+
+	// "otlp", "stdout" or "file", tracing is off if empty
+	TraceExporter    string            `json:"traceExporter"`
+	TraceEndpoint    string            `json:"traceEndpoint"`
+	TraceHeaders     map[string]string `json:"traceHeaders"`
+	TraceFile        string            `json:"traceFile"`
+	TraceSampleRatio *float64          `json:"traceSampleRatio"`
 }
 
-func GetDbConfig() (string, error) {
+func GetConfig() (Config, error) {
 	var config Config
 
 	file, _ := os.Open(os.Getenv("CONFIG_PATH"))
 	decoder := json.NewDecoder(file)
 	err := decoder.Decode(&config)
+	return config, err
+}
+
+func GetDbConfig() (string, error) {
+	config, err := GetConfig()
 	if err != nil {
 		return "", err
 	}
@@ -38,6 +100,9 @@ func GetDbConfig() (string, error) {
 }
 
 func InitDb(driver, connString string) error {
+	if driver == "sqlite3" {
+		connString = sqlite3ForeignKeys(connString)
+	}
 	db, err := sql.Open(driver, connString)
 	if err != nil {
 		return err
@@ -50,16 +115,116 @@ func InitDb(driver, connString string) error {
 		dialect = srm.PostgresDialect{}
 	case "sqlite3":
 		dialect = srm.Sqlite3Dialect{}
+		err = CheckSqlite3Fts5(db)
+		if err != nil {
+			return err
+		}
 	default:
 		return errors.New("global.InitDb: Driver not supported")
 	}
 
-	Db = &srm.DbMap{Conn: db, Dialect: dialect}
+	observe := func(op srm.Operation) {
+		metrics.ObserveDbOperation(op)
+		tracing.ObserveDbOperation(driver, op)
+	}
+	Db = &srm.DbMap{Conn: db, Dialect: dialect, Observe: observe}
+	metrics.WatchDbPool(db)
+
+	return nil
+}
+
+// SQLite only enforces foreign keys when asked to, per connection
+func sqlite3ForeignKeys(connString string) string {
+	if strings.Contains(connString, "_foreign_keys=") || strings.Contains(connString, "_fk=") {
+		return connString
+	}
+	if strings.Contains(connString, "?") {
+		return connString + "&_foreign_keys=1"
+	}
+	return connString + "?_foreign_keys=1"
+}
+
+// The sqlite3 migrations index search documents with FTS5, which go-sqlite3
+// only compiles in when built with -tags sqlite_fts5
+func CheckSqlite3Fts5(db *sql.DB) error {
+	var enabled int
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	if err != nil {
+		return err
+	}
+	if enabled == 0 {
+		return errors.New("global.CheckSqlite3Fts5: sqlite3 built without FTS5, build with -tags sqlite_fts5")
+	}
+	return nil
+}
+
+// level is debug, info, warn or error, and format json or text
+func InitLogger(w io.Writer, level string, format string) error {
+	l, err := logging.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logger, err := logging.New(w, l, format)
+	if err != nil {
+		return err
+	}
+	Logger = logger
+	return nil
+}
+
+// Where OTLP spans are posted without a traceEndpoint, from
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT if set
+var DefaultTraceEndpoint string = getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+	"http://localhost:4318/v1/traces")
 
+// Starts exporting spans as the config asks, sampling traceSampleRatio of new
+// traces (all by default). Tracing stays off without a traceExporter.
+func InitTracer(config Config) error {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.TraceExporter {
+	case "":
+		return nil
+	case "otlp":
+		endpoint := config.TraceEndpoint
+		if endpoint == "" {
+			endpoint = DefaultTraceEndpoint
+		}
+		exporter, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(endpoint), otlptracehttp.WithHeaders(config.TraceHeaders))
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		if config.TraceFile == "" {
+			return errors.New("global.InitTracer: traceFile not set")
+		}
+		var f *os.File
+		f, err = os.OpenFile(config.TraceFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return errors.New(fmt.Sprintf("Unknown trace exporter %s, expected otlp, stdout or file",
+			config.TraceExporter))
+	}
+	if err != nil {
+		return err
+	}
+	sampleRatio := 1.0
+	if config.TraceSampleRatio != nil {
+		sampleRatio = *config.TraceSampleRatio
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		Logger.Warn("Spans not exported", "error", err)
+	}))
+	otel.SetTracerProvider(tracing.NewProvider(exporter, sampleRatio))
 	return nil
 }
 
-// TODO: glog?
-func InitLogger(w io.Writer) {
-	Logger = log.New(w, "INFO: ", log.LstdFlags)
+func getEnv(key, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
 }
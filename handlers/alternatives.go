@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+var (
+	AlternativesLengthDefault int    = 10
+	AlternativesLengthMax     int    = 50
+	AlternativesRankByDefault string = "fuelCost"
+	AlternativesYearWindow    int    = 1
+)
+
+// Vehicles from the same or adjacent model years with comparable size class,
+// drive and passenger/luggage volume, ranked by annual fuel cost (rankBy=fuelCost)
+// or CO2 (rankBy=co2) saved under the driving profile. Only alternatives that
+// save something are returned unless greenerOnly=false.
+func VehicleGetAlternatives(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	queryVals := r.URL.Query()
+	profile, paramErr := getProfileFromQueryVals(queryVals)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	rankBy := queryVals.Get("rankBy")
+	if rankBy == "" {
+		rankBy = AlternativesRankByDefault
+	}
+	if rankBy != "fuelCost" && rankBy != "co2" {
+		sendParamError(w, newParamError("rankBy", "Unsupported rankBy: %s", rankBy))
+		return
+	}
+	greenerOnly := queryVals.Get("greenerOnly") != "false"
+	limit, paramErr := getBoundedIntFromQueryVals(queryVals, "limit", AlternativesLengthDefault, 1, AlternativesLengthMax)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+
+	v := models.Vehicle{}
+	query := fmt.Sprintf("SELECT * FROM vehicles WHERE epa_id = %s",
+		global.Db.Dialect.Placeholder(1))
+	err := dbOf(r).SelectOne(&v, query, id)
+	if srm.ErrorKind(err) == srm.ErrNotFound || v.Status == models.VehicleDeleted {
+		sendErrorJSON(w, fmt.Sprintf("Vehicle not found: %d", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		checkErr(err, w)
+		return
+	}
+
+	fp := getMostRecentFuelPrices()
+	v.Fuels = models.CalculateFuelData(&v, profile, fp)
+
+	sizeClasses := make([]interface{}, 0)
+	for _, sizeClass := range models.ComparableSizeClasses(v.SizeClass) {
+		sizeClasses = append(sizeClasses, sizeClass)
+	}
+	queryBuilder := &srm.QueryBuilder{
+		Db:    dbOf(r),
+		Table: "vehicles",
+		WhereIn: map[string][]interface{}{
+			"size_class": sizeClasses,
+			"status":     getStatusesFromQueryVals(queryVals),
+		},
+		WhereRange: map[string]srm.Range{"year": srm.Range{
+			Min: v.Year - AlternativesYearWindow,
+			Max: v.Year + AlternativesYearWindow,
+		}},
+	}
+	query, vals := queryBuilder.BuildSelect()
+	candidates := make([]models.Vehicle, 0)
+	err = dbOf(r).SelectMany(&candidates, query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+
+	alts := make([]models.Alternative, 0)
+	for i := range candidates {
+		c := &candidates[i]
+		if c.EpaID == v.EpaID {
+			continue
+		}
+		c.Fuels = models.CalculateFuelData(c, profile, fp)
+		alt, ok := models.NewAlternative(&v, c, profile)
+		if !ok || (greenerOnly && alternativeSaving(alt, rankBy) <= 0) {
+			continue
+		}
+		alts = append(alts, alt)
+	}
+	sort.SliceStable(alts, func(i, j int) bool {
+		return alternativeSaving(alts[i], rankBy) > alternativeSaving(alts[j], rankBy)
+	})
+	if len(alts) > limit {
+		alts = alts[:limit]
+	}
+
+	js, err := json.Marshal(AlternativesResponse{profile, rankBy, v, alts})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+func alternativeSaving(alt models.Alternative, rankBy string) float64 {
+	if rankBy == "co2" {
+		return alt.AnnualCo2SavedKg
+	}
+	return float64(alt.AnnualFuelCostSaved)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/auth"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+type contextKey string
+
+const apiKeyContextKey contextKey = "apiKey"
+
+// Identifies the API key a request was made with, if any. Requests with an
+// unknown or revoked key are refused rather than treated as anonymous, but
+// spend a token from the address's anonymous bucket first, so keys can't be
+// guessed faster than anonymous requests are allowed.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := getKeyFromRequest(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		apiKey, err := auth.LookupKey(key)
+		if err == auth.ErrInvalidKey {
+			if takeToken(w, r, anonymousBucket(r), models.PlanAnonymous, 0) {
+				sendUnauthorized(w, err.Error())
+			}
+			return
+		} else if checkErr(err, w) {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, apiKey)))
+	})
+}
+
+// Keys are sent as "Authorization: Bearer <key>" or "X-API-Key: <key>"
+func getKeyFromRequest(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// The key a request was made with, nil if anonymous
+func apiKeyOf(r *http.Request) *models.APIKey {
+	apiKey, _ := r.Context().Value(apiKeyContextKey).(*models.APIKey)
+	return apiKey
+}
+
+// Refuses requests without a key that has the scope
+func requireScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := apiKeyOf(r)
+		if apiKey == nil {
+			sendUnauthorized(w, "An API key is required")
+			return
+		}
+		if !apiKey.HasScope(scope) {
+			sendErrorJSON(w, "API key lacks the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func sendUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	sendErrorJSON(w, message, http.StatusUnauthorized)
+}
+
+// Records an action taken with the request's key. Requests that can't be
+// audited aren't acted on, so this is called before the action, or in the
+// same transaction as it.
+func audit(db *srm.DbMap, r *http.Request, action string, target string, jobID int) error {
+	return auth.Audit(db, apiKeyOf(r), action, target, jobID, r.RemoteAddr)
+}
+
+// The audit log, newest first
+func AuditGet(w http.ResponseWriter, r *http.Request) {
+	queryVals := r.URL.Query()
+	page, paramErr := getPageFromQueryVals(queryVals, r.URL)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	entries, total, err := auth.GetAuditLog(page.PageLength, page.PageLength*(page.PageNo-1))
+	if checkErr(err, w) {
+		return
+	}
+	page.Fill(queryVals, total)
+
+	js, err := json.Marshal(AuditResponse{*page, entries})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+var (
+	BatchLengthMax int   = 100
+	BatchBodyMax   int64 = 16 << 10 // Bytes, ample for BatchLengthMax ids
+)
+
+type batchRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// Looks up vehicles by comma separated epa IDs (GET ?ids=1,2,3) or a JSON body
+// (POST {"ids": [1, 2, 3]}), under one driving profile. Vehicles come back in
+// the order requested, with IDs that weren't found or are deleted listed
+// separately.
+func VehicleGetBatch(w http.ResponseWriter, r *http.Request) {
+	queryVals := r.URL.Query()
+	profile, paramErr := getProfileFromQueryVals(queryVals)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+
+	var ids []int
+	if r.Method == "POST" {
+		var br batchRequest
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, BatchBodyMax))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			sendErrorJSON(w, fmt.Sprintf("Request body must be at most %d bytes", BatchBodyMax),
+				http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			sendErrorJSON(w, "Request body could not be read", http.StatusBadRequest)
+			return
+		}
+		err = json.Unmarshal(body, &br)
+		if err != nil {
+			sendErrorJSON(w, "Request body must be JSON: {\"ids\": [...]}", http.StatusBadRequest)
+			return
+		}
+		ids = br.IDs
+	} else {
+		for _, val := range queryVals["ids"] {
+			for _, idStr := range strings.Split(val, ",") {
+				id, err := strconv.Atoi(strings.TrimSpace(idStr))
+				if err != nil {
+					sendParamError(w, newParamError("ids", "Invalid id: %s", idStr))
+					return
+				}
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		sendParamError(w, newParamError("ids", "Missing required parameter: ids"))
+		return
+	}
+	if len(ids) > BatchLengthMax {
+		sendParamError(w, newParamError("ids", "At most %d ids per batch", BatchLengthMax))
+		return
+	}
+
+	// One query for vehicles and one for their emissions info
+	epaIds := make([]interface{}, len(ids))
+	for i, id := range ids {
+		epaIds[i] = id
+	}
+	// Withdrawn vehicles can still be looked up by ID, as they can singly
+	queryBuilder := &srm.QueryBuilder{
+		Db:    dbOf(r),
+		Table: "vehicles",
+		WhereIn: map[string][]interface{}{
+			"epa_id": epaIds,
+			"status": []interface{}{models.VehicleActive, models.VehicleWithdrawn},
+		},
+	}
+	vs, err := selectVehicles(r.Context(), queryBuilder, profile, true)
+	if checkErr(err, w) {
+		return
+	}
+
+	// Restore requested order
+	byEpaId := make(map[int]models.Vehicle, len(vs))
+	for _, v := range vs {
+		byEpaId[v.EpaID] = v
+	}
+	ordered := make([]models.Vehicle, 0, len(ids))
+	notFound := make([]int, 0)
+	for _, id := range ids {
+		if v, ok := byEpaId[id]; ok {
+			ordered = append(ordered, v)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+
+	js, err := json.Marshal(BatchResponse{profile, ordered, notFound})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/teasherm/fueleconomy/cache"
+	"github.com/teasherm/fueleconomy/models"
+)
+
+// Vehicle responses only change when the dataset version does, so clients
+// may reuse them briefly and revalidate after
+const VehicleCacheControl string = "public, max-age=300, must-revalidate"
+
+// Profile parameters are left out of a normalised query in favour of the
+// profile they parsed to
+var profileParams []string = []string{"cityShare", "highwayShare", "milesPerYear"}
+
+// The path and query of a request with empty parameters dropped, parameters
+// sorted and the driving profile as parsed, so that equivalent requests
+// share an ETag
+func normalisedQuery(r *http.Request, profile models.DrivingProfile) string {
+	vals := url.Values{}
+	for param, paramVals := range r.URL.Query() {
+		if isProfileParam(param) {
+			continue
+		}
+		for _, val := range paramVals {
+			if val != "" {
+				vals.Add(param, val)
+			}
+		}
+	}
+	return fmt.Sprintf("%s?%s#%+v", r.URL.Path, vals.Encode(), profile)
+}
+
+func isProfileParam(param string) bool {
+	for _, p := range profileParams {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// A strong ETag over the parts a response is derived from
+func makeETag(parts ...interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(parts...)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Sets the caching headers of a response and answers with 304 if the
+// request's validators match, returning true if it did. If-None-Match takes
+// precedence over If-Modified-Since.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", VehicleCacheControl)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == etag || tag == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err == nil && !lastModified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// Answers from the response cache if it has the request, returning true if
+// it did
+func sendCachedResponse(w http.ResponseWriter, r *http.Request, key string) bool {
+	cached, ok := cache.Responses.Get(key)
+	if !ok {
+		return false
+	}
+	if !checkNotModified(w, r, cached.ETag, cached.LastModified) {
+		sendJSON(w, cached.Body)
+	}
+	return true
+}
+
+// Response cache and fuel prices cache stats
+func CacheGet(w http.ResponseWriter, r *http.Request) {
+	js, err := json.Marshal(CacheResponse{cache.Responses.Stats(), cache.FuelPricesStats()})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// The latest of some times, zero if all are zero
+func latest(times ...time.Time) time.Time {
+	var t time.Time
+	for _, ti := range times {
+		if ti.After(t) {
+			t = ti
+		}
+	}
+	return t
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+var (
+	// Year -> make -> model -> option drill down, each level exact matched
+	CatalogParams []searchParam = []searchParam{
+		searchParam{name: "year", converter: intConverter},
+		searchParam{name: "make", converter: stringConverter},
+		searchParam{name: "model", converter: stringConverter},
+	}
+
+	AutocompleteLengthDefault int = 10
+	AutocompleteLengthMax     int = 25
+)
+
+func CatalogGetYears(w http.ResponseWriter, r *http.Request) {
+	queryBuilder := catalogQueryBuilder(r, nil)
+	query, vals := queryBuilder.BuildDistinct("year")
+	years, err := dbOf(r).SelectInts(query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+
+	js, err := json.Marshal(YearsResponse{years})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+func CatalogGetMakes(w http.ResponseWriter, r *http.Request) {
+	whereExact, paramErr := extractSearchParams(r.URL.Query(), CatalogParams[:1])
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	queryBuilder := catalogQueryBuilder(r, whereExact)
+	query, vals := queryBuilder.BuildDistinct("make")
+	makes, err := dbOf(r).SelectStrings(query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+
+	js, err := json.Marshal(MakesResponse{makes})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+func CatalogGetModels(w http.ResponseWriter, r *http.Request) {
+	whereExact, ok := requireCatalogParams(w, r, CatalogParams[:2])
+	if !ok {
+		return
+	}
+	queryBuilder := catalogQueryBuilder(r, whereExact)
+	query, vals := queryBuilder.BuildDistinct("model")
+	modelNames, err := dbOf(r).SelectStrings(query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+
+	js, err := json.Marshal(ModelsResponse{modelNames})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+func CatalogGetOptions(w http.ResponseWriter, r *http.Request) {
+	whereExact, ok := requireCatalogParams(w, r, CatalogParams)
+	if !ok {
+		return
+	}
+	queryBuilder := catalogQueryBuilder(r, whereExact)
+	query, vals := queryBuilder.BuildDistinct("epa_id", "cylinders", "drive_axle_type",
+		"eng_displacement", "eng_dscr", "fuel_type", "trans_dscr", "transition")
+	options := make([]models.VehicleOption, 0)
+	err := dbOf(r).SelectMany(&options, query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+
+	js, err := json.Marshal(OptionsResponse{options})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Escapes LIKE's wildcards, and its escape character, so a prefix only matches
+// itself
+var likeEscaper *strings.Replacer = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Prefix suggestions across makes, then models. Model suggestions match on
+// the model alone or on "make model". Like the catalog, only listed vehicles
+// are suggested.
+func Autocomplete(w http.ResponseWriter, r *http.Request) {
+	queryVals := r.URL.Query()
+	prefix := strings.ToLower(strings.TrimSpace(queryVals.Get("q")))
+	if prefix == "" {
+		sendParamError(w, newParamError("q", "Missing required parameter: q"))
+		return
+	}
+	limit, paramErr := getBoundedIntFromQueryVals(queryVals, "limit", AutocompleteLengthDefault, 1, AutocompleteLengthMax)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	ph := global.Db.Dialect.Placeholder
+	statuses := getStatusesFromQueryVals(queryVals)
+	pattern := likeEscaper.Replace(prefix) + "%"
+
+	suggestions := make([]models.Suggestion, 0)
+	query := fmt.Sprintf("SELECT DISTINCT make FROM vehicles WHERE lower(make) LIKE %s ESCAPE '\\' AND %s "+
+		"ORDER BY make LIMIT %s", ph(1), statusCondition(len(statuses), 2), ph(2+len(statuses)))
+	args := append(append([]interface{}{pattern}, statuses...), limit)
+	err := dbOf(r).SelectMany(&suggestions, query, args...)
+	if checkErr(err, w) {
+		return
+	}
+
+	modelSuggestions := make([]models.Suggestion, 0)
+	query = fmt.Sprintf("SELECT DISTINCT make, model FROM vehicles WHERE (lower(model) LIKE %s ESCAPE '\\' "+
+		"OR lower(make || ' ' || model) LIKE %s ESCAPE '\\') AND %s ORDER BY make, model LIMIT %s",
+		ph(1), ph(2), statusCondition(len(statuses), 3), ph(3+len(statuses)))
+	args = append(append([]interface{}{pattern, pattern}, statuses...), limit-len(suggestions))
+	err = dbOf(r).SelectMany(&modelSuggestions, query, args...)
+	if checkErr(err, w) {
+		return
+	}
+	suggestions = append(suggestions, modelSuggestions...)
+
+	for i := range suggestions {
+		s := &suggestions[i]
+		s.Text = strings.TrimSpace(fmt.Sprintf("%s %s", s.Make, s.Model))
+	}
+
+	js, err := json.Marshal(AutocompleteResponse{suggestions})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Each level of the drill down needs every level above it
+func requireCatalogParams(w http.ResponseWriter, r *http.Request, params []searchParam) (map[string]interface{}, bool) {
+	queryVals := r.URL.Query()
+	for _, param := range params {
+		if queryVals.Get(param.name) == "" {
+			sendParamError(w, newParamError(param.name, "Missing required parameter: %s", param.name))
+			return nil, false
+		}
+	}
+	whereExact, paramErr := extractSearchParams(queryVals, params)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return nil, false
+	}
+	return whereExact, true
+}
+
+// Catalog levels list what /vehicles would, so withdrawn vehicles only with
+// includeWithdrawn=true
+func catalogQueryBuilder(r *http.Request, whereExact map[string]interface{}) *srm.QueryBuilder {
+	return &srm.QueryBuilder{
+		Db:         dbOf(r),
+		Table:      "vehicles",
+		WhereExact: whereExact,
+		WhereIn:    map[string][]interface{}{"status": getStatusesFromQueryVals(r.URL.Query())},
+	}
+}
+
+// status IN (...) with n placeholders numbered from start, for queries the
+// QueryBuilder can't build
+func statusCondition(n int, start int) string {
+	placeholders := make([]string, n)
+	for i := range placeholders {
+		placeholders[i] = global.Db.Dialect.Placeholder(start + i)
+	}
+	return fmt.Sprintf("status IN (%s)", strings.Join(placeholders, ", "))
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/export"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+// Rows written between flushes when streaming an export
+var ExportFlushInterval int = 500
+
+// Streams every vehicle matching the VehicleGetMany filters, as CSV unless
+// another format is asked for. Fuels are flattened into fuel1_* and fuel2_*
+// columns, see models.FlatColumns.
+func VehicleExport(w http.ResponseWriter, r *http.Request) {
+	queryVals := r.URL.Query()
+	profile, paramErr := getProfileFromQueryVals(queryVals)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	format, ok, unsupported := getExportFormat(queryVals, r.Header)
+	if unsupported != "" {
+		sendParamError(w, newParamError("format", "Unsupported format: %s", unsupported))
+		return
+	}
+	if !ok {
+		format = export.Formats["csv"]
+	}
+	whereExact, paramErr := extractSearchParams(queryVals, ExactParams)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	queryBuilder := &srm.QueryBuilder{
+		Db:         dbOf(r),
+		Table:      "vehicles",
+		WhereExact: whereExact,
+		WhereFuzzy: extractStringParams(queryVals, FuzzyParams),
+		WhereIn:    map[string][]interface{}{"status": getStatusesFromQueryVals(queryVals)},
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="vehicles.%s"`, format.Extension))
+	ew := format.NewWriter(w)
+	err := ew.WriteHeader(models.FlatColumns())
+	if checkErr(err, w) {
+		return
+	}
+
+	// Rows are written as they're read off the cursor
+	fp := getMostRecentFuelPrices()
+	flusher, canFlush := w.(http.Flusher)
+	rows := 0
+	v := models.Vehicle{}
+	query, vals := queryBuilder.BuildSelect()
+	err = dbOf(r).SelectEach(&v, func() error {
+		v.Fuels = models.CalculateFuelData(&v, profile, fp)
+		if err := ew.WriteRow(v.FlatRecord()); err != nil {
+			return err
+		}
+		rows++
+		if canFlush && rows%ExportFlushInterval == 0 {
+			flusher.Flush()
+		}
+		return nil
+	}, query, vals...)
+	if err != nil {
+		// Headers are already sent so the export is just cut short
+		loggerOf(r).Error("Export cut short", "error", err, "rows", rows)
+	}
+	err = ew.Close()
+	if err != nil {
+		loggerOf(r).Error("Export not closed", "error", err)
+	}
+}
+
+// Writes vehicles in a non-JSON format as an attachment
+func sendExport(w http.ResponseWriter, format export.Format, vs []models.Vehicle) {
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="vehicles.%s"`, format.Extension))
+	ew := format.NewWriter(w)
+	err := ew.WriteHeader(models.FlatColumns())
+	for i := 0; err == nil && i < len(vs); i++ {
+		err = ew.WriteRow(vs[i].FlatRecord())
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		responseLogger(w).Error("Export cut short", "error", err)
+	}
+}
+
+// Export format from the format parameter, falling back to the first
+// supported type in the Accept header. ok is false when JSON (or nothing in
+// particular) was asked for.
+func getExportFormat(queryVals url.Values, header http.Header) (format export.Format, ok bool, unsupported string) {
+	if name := queryVals.Get("format"); name != "" {
+		if name == "json" {
+			return format, false, ""
+		}
+		format, ok = export.Formats[name]
+		if !ok {
+			return format, false, name
+		}
+		return format, true, ""
+	}
+
+	for _, accepted := range strings.Split(header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/json" {
+			return format, false, ""
+		}
+		if format, ok = export.FormatForContentType(mediaType); ok {
+			return format, true, ""
+		}
+	}
+	return format, false, ""
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+var (
+	YearBucketSize int = 5
+
+	// Facet names and the vehicles column expression each groups by
+	Facets map[string]string = map[string]string{
+		"driveAxleType": "drive_axle_type",
+		"fuelType":      "fuel_type",
+		"make":          "make",
+		"sizeClass":     "size_class",
+		"year":          "year",
+		"yearBucket":    fmt.Sprintf("(year / %d) * %d", YearBucketSize, YearBucketSize),
+	}
+)
+
+// Parses the comma separated facets parameter, returning the first
+// unsupported facet name if any
+func getFacetsFromQueryVals(queryVals url.Values) (facets []string, unsupported string) {
+	for _, facet := range strings.Split(queryVals.Get("facets"), ",") {
+		facet = strings.TrimSpace(facet)
+		if facet == "" {
+			continue
+		}
+		if _, ok := Facets[facet]; !ok {
+			return nil, facet
+		}
+		facets = append(facets, facet)
+	}
+	return facets, ""
+}
+
+// Counts vehicles per facet value under the query builder's filters
+func selectFacets(queryBuilder *srm.QueryBuilder, facets []string) (map[string][]models.FacetCount, error) {
+	out := make(map[string][]models.FacetCount)
+	for _, facet := range facets {
+		query, vals := queryBuilder.BuildGroupBy(Facets[facet], "COUNT(*) AS count")
+		counts := make([]models.FacetCount, 0)
+		err := queryBuilder.Db.SelectMany(&counts, query, vals...)
+		if err != nil {
+			return out, err
+		}
+		out[facet] = counts
+	}
+	return out, nil
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/models"
+)
+
+// Sparse fieldset from the fields and include parameters, e.g.
+// fields=make,model,year,fuels.mpgComb,fuels.fuelCost&include=emissions
+type fieldSelection struct {
+	fields        map[string]bool // top level vehicle fields, nil for all
+	fuelFields    map[string]bool // fuel fields when only some were asked for
+	allFuelFields bool
+	emissions     bool // whether to query emissions info
+}
+
+// Returns the first unsupported field or include if any. Without either
+// parameter the full vehicle is returned, emissions info included.
+func getFieldSelectionFromQueryVals(queryVals url.Values) (fs *fieldSelection, unsupported string) {
+	fs = &fieldSelection{}
+	fieldsVal, includeVal := queryVals.Get("fields"), queryVals.Get("include")
+	if fieldsVal == "" && includeVal == "" {
+		fs.emissions = true
+		return fs, ""
+	}
+
+	for _, include := range splitList(includeVal) {
+		if include != "emissions" {
+			return nil, include
+		}
+		fs.emissions = true
+	}
+
+	if fieldsVal == "" {
+		return fs, ""
+	}
+	fs.fields = make(map[string]bool)
+	for _, field := range splitList(fieldsVal) {
+		switch {
+		case strings.HasPrefix(field, "fuels."):
+			fuelField := strings.TrimPrefix(field, "fuels.")
+			if !models.FuelFieldNames[fuelField] {
+				return nil, field
+			}
+			if fs.fuelFields == nil {
+				fs.fuelFields = make(map[string]bool)
+			}
+			fs.fuelFields[fuelField] = true
+			fs.fields["fuels"] = true
+		case field == "fuels":
+			fs.allFuelFields = true
+			fs.fields[field] = true
+		case field == "emissionsInfo":
+			fs.emissions = true
+			fs.fields[field] = true
+		default:
+			if _, ok := models.VehicleFieldColumns[field]; !ok {
+				return nil, field
+			}
+			fs.fields[field] = true
+		}
+	}
+	return fs, ""
+}
+
+// Columns to select from vehicles, nil for all. epa_id is always selected to
+// join emissions info and rankings on.
+func (fs *fieldSelection) Columns() []string {
+	return fs.ColumnsWith()
+}
+
+// Columns along with others a handler needs whatever the fields selected
+func (fs *fieldSelection) ColumnsWith(extra ...string) []string {
+	if fs.fields == nil {
+		return nil
+	}
+	seen := map[string]bool{"epa_id": true}
+	cols := []string{"epa_id"}
+	add := func(col string) {
+		if col != "" && !seen[col] {
+			seen[col] = true
+			cols = append(cols, col)
+		}
+	}
+	for _, col := range extra {
+		add(col)
+	}
+	for field := range fs.fields {
+		add(models.VehicleFieldColumns[field])
+	}
+	if fs.fields["fuels"] {
+		for _, col := range models.FuelDataColumns {
+			add(col)
+		}
+	}
+	return cols
+}
+
+func (fs *fieldSelection) Rankings() bool {
+	return fs.fields == nil || fs.fields["rankings"]
+}
+
+// Prunes a vehicle down to the selected fields
+func (fs *fieldSelection) Apply(v models.Vehicle) (interface{}, error) {
+	if !fs.emissions {
+		v.EmissionsInfo = nil
+	}
+	if fs.fields == nil {
+		return v, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var full map[string]interface{}
+	err = json.Unmarshal(b, &full)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{})
+	for field := range fs.fields {
+		if val, ok := full[field]; ok {
+			out[field] = val
+		}
+	}
+	if fuels, ok := out["fuels"].([]interface{}); ok && !fs.allFuelFields {
+		for i, fuel := range fuels {
+			full := fuel.(map[string]interface{})
+			pruned := make(map[string]interface{})
+			for field := range fs.fuelFields {
+				if val, ok := full[field]; ok {
+					pruned[field] = val
+				}
+			}
+			fuels[i] = pruned
+		}
+	}
+	if eis, ok := full["emissionsInfo"]; ok && fs.emissions {
+		out["emissionsInfo"] = eis
+	}
+	return out, nil
+}
+
+func (fs *fieldSelection) ApplyAll(vs []models.Vehicle) ([]interface{}, error) {
+	out := make([]interface{}, len(vs))
+	for i, v := range vs {
+		pruned, err := fs.Apply(v)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = pruned
+	}
+	return out, nil
+}
+
+func splitList(val string) []string {
+	out := make([]string, 0)
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -2,48 +2,107 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
 
+	"github.com/teasherm/fueleconomy/cache"
 	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/metrics"
 	"github.com/teasherm/fueleconomy/models"
 	"github.com/teasherm/fueleconomy/srm"
+	"github.com/teasherm/fueleconomy/tracing"
 	"github.com/teasherm/fueleconomy/workers"
 )
 
 func NewRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(requestIDMiddleware)
+	r.Use(tracingMiddleware)
+	r.Use(accessLogMiddleware)
+	r.Use(metricsMiddleware)
+	r.Use(authMiddleware)
+	r.Use(rateLimitMiddleware)
 
+	r.HandleFunc("/admin/audit", requireScope(models.ScopeAdmin, AuditGet)).Methods("GET")
+	r.HandleFunc("/admin/cache", requireScope(models.ScopeAdmin, CacheGet)).Methods("GET")
+	r.HandleFunc("/admin/keys", requireScope(models.ScopeAdmin, KeysGet)).Methods("GET")
+	r.HandleFunc("/admin/keys", requireScope(models.ScopeAdmin, KeyCreate)).Methods("POST")
+	r.HandleFunc("/admin/keys/{id:[0-9]+}", requireScope(models.ScopeAdmin, KeyRevoke)).Methods("DELETE")
+	r.HandleFunc("/admin/keys/{id:[0-9]+}/usage", requireScope(models.ScopeAdmin, KeyGetUsage)).Methods("GET")
+	r.HandleFunc("/autocomplete", Autocomplete).Methods("GET")
 	r.HandleFunc("/health_check", HealthCheck).Methods("GET")
-	r.HandleFunc("/ingest/{target}", Ingest).Methods("GET")
+	r.HandleFunc("/ingest/{target}", requireScope(models.ScopeIngest, Ingest)).Methods("POST")
+	r.HandleFunc("/jobs/{id:[0-9]+}", requireScope(models.ScopeIngest, JobGetOne)).Methods("GET")
+	r.HandleFunc("/jobs/{id:[0-9]+}/approve", requireScope(models.ScopeIngest, JobApprove)).Methods("POST")
+	r.HandleFunc("/jobs/{id:[0-9]+}/diff", requireScope(models.ScopeIngest, JobGetDiff)).Methods("GET")
+	r.HandleFunc("/jobs/{id:[0-9]+}/quality", requireScope(models.ScopeIngest, JobGetQuality)).Methods("GET")
+	r.HandleFunc("/makes", CatalogGetMakes).Methods("GET")
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	r.HandleFunc("/models", CatalogGetModels).Methods("GET")
+	r.HandleFunc("/options", CatalogGetOptions).Methods("GET")
+	r.HandleFunc("/search", VehicleSearch).Methods("GET")
+	r.HandleFunc("/snapshots", SnapshotsGet).Methods("GET")
+	r.HandleFunc("/snapshots/{version}", SnapshotGetOne).Methods("GET")
+	r.HandleFunc("/snapshots/{version}/{file}", SnapshotGetFile).Methods("GET")
+	r.HandleFunc("/stats", StatsGet).Methods("GET")
+	r.HandleFunc("/trends", TrendsGet).Methods("GET")
 	r.HandleFunc("/vehicle/{id:[0-9]+}", VehicleGetOne).Methods("GET")
+	r.HandleFunc("/vehicle/{id:[0-9]+}/alternatives", VehicleGetAlternatives).Methods("GET")
 	r.HandleFunc("/vehicles", VehicleGetMany).Methods("GET")
+	r.HandleFunc("/vehicles/batch", VehicleGetBatch).Methods("GET", "POST")
+	r.HandleFunc("/vehicles/export", VehicleExport).Methods("GET")
+	r.HandleFunc("/years", CatalogGetYears).Methods("GET")
 
 	return r
 }
 
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	err := global.Db.Conn.Ping()
-	checkErr(err, w)
+	if checkErr(err, w) {
+		return
+	}
 
 	js, err := json.Marshal(SimpleResponse{"Healthy!"})
-	checkErr(err, w)
+	if checkErr(err, w) {
+		return
+	}
 	sendJSON(w, js)
 }
 
 func Ingest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	target := vars["target"]
-	work, err := workers.GenerateWorkRequest(target)
-	checkErr(err, w)
+	queryVals := r.URL.Query()
+	dryRun := queryVals.Get("dryRun") == "true"
+
+	// Audited with the job, so neither is recorded without the other
+	var work workers.WorkRequest
+	var workErr error
+	err := dbOf(r).Transaction(nil, func(tx *srm.DbMap) error {
+		work, workErr = workers.GenerateWorkRequest(tx, target, queryVals.Get("source"), dryRun)
+		if workErr != nil {
+			return workErr
+		}
+		return audit(tx, r, "ingest", target, work.Job.ID)
+	})
+	if workErr != nil {
+		sendErrorJSON(w, workErr.Error(), http.StatusBadRequest)
+		return
+	} else if checkErr(err, w) {
+		return
+	}
 	workers.WorkQueue <- work
 
-	js, err := json.Marshal(SimpleResponse{fmt.Sprintf("Ingest kicked off for: %s", target)})
-	checkErr(err, w)
+	js, err := json.Marshal(IngestResponse{fmt.Sprintf("Ingest kicked off for: %s", target), work.Job})
+	if checkErr(err, w) {
+		return
+	}
 	sendJSON(w, js)
 }
 
@@ -52,26 +111,79 @@ func VehicleGetOne(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(vars["id"])
 
 	queryVals := r.URL.Query()
-	profile := getProfileFromQueryVals(queryVals)
+	profile, paramErr := getProfileFromQueryVals(queryVals)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	fs, unsupported := getFieldSelectionFromQueryVals(queryVals)
+	if unsupported != "" {
+		sendParamError(w, newParamError("fields", "Unsupported field: %s", unsupported))
+		return
+	}
+	cacheKey, generation := normalisedQuery(r, profile), cache.Responses.Generation()
+	if sendCachedResponse(w, r, cacheKey) {
+		return
+	}
 
 	v := models.Vehicle{}
-	eis := make([]models.EmissionsInfo, 0)
-	query := fmt.Sprintf("SELECT * FROM vehicles WHERE epa_id = %s",
-		global.Db.Dialect.Placeholder(1))
-	err := global.Db.SelectOne(&v, query, id)
-	checkErr(err, w)
+	queryBuilder := &srm.QueryBuilder{
+		Db:         dbOf(r),
+		Table:      "vehicles",
+		Columns:    fs.ColumnsWith("updated", "status"),
+		WhereExact: map[string]interface{}{"epa_id": id},
+	}
+	query, vals := queryBuilder.BuildSelect()
+	err := dbOf(r).SelectOne(&v, query, vals...)
+	if srm.ErrorKind(err) == srm.ErrNotFound || v.Status == models.VehicleDeleted {
+		sendErrorJSON(w, fmt.Sprintf("Vehicle not found: %d", id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		checkErr(err, w)
+		return
+	}
 
 	fp := getMostRecentFuelPrices()
+	version, err := workers.GetDatasetVersion()
+	if checkErr(err, w) {
+		return
+	}
+	etag := makeETag(version.ID, v.Updated.UnixNano(), fp.ID, cacheKey)
+	lastModified := latest(v.Updated, fp.Updated, version.CreatedAt)
+	if checkNotModified(w, r, etag, lastModified) {
+		return
+	}
+
 	v.Fuels = models.CalculateFuelData(&v, profile, fp)
 
-	query = fmt.Sprintf("SELECT * FROM emissions_info WHERE epa_id = %s",
-		global.Db.Dialect.Placeholder(1))
-	err = global.Db.SelectMany(&eis, query, id)
-	checkErr(err, w)
-	v.EmissionsInfo = eis
+	if fs.emissions {
+		eis := make([]models.EmissionsInfo, 0)
+		query = fmt.Sprintf("SELECT * FROM emissions_info WHERE epa_id = %s",
+			global.Db.Dialect.Placeholder(1))
+		err = dbOf(r).SelectMany(&eis, query, id)
+		if checkErr(err, w) {
+			return
+		}
+		v.EmissionsInfo = eis
+	}
+
+	if fs.Rankings() {
+		v.Rankings, err = getVehicleRankings(r.Context(), id)
+		if checkErr(err, w) {
+			return
+		}
+	}
+
+	out, err := fs.Apply(v)
+	if checkErr(err, w) {
+		return
+	}
 
-	js, err := json.Marshal(VehicleResponse{profile, v})
-	checkErr(err, w)
+	js, err := json.Marshal(VehicleResponse{profile, out})
+	if checkErr(err, w) {
+		return
+	}
+	cache.Responses.Add(cacheKey, generation, cache.Response{Body: js, ETag: etag, LastModified: lastModified})
 	sendJSON(w, js)
 }
 
@@ -89,47 +201,143 @@ var (
 func VehicleGetMany(w http.ResponseWriter, r *http.Request) {
 	// Parse querystring parameters and make sql query builder
 	queryVals := r.URL.Query()
-	profile := getProfileFromQueryVals(queryVals)
-	page := getPageFromQueryVals(queryVals, r.URL)
+	profile, paramErr := getProfileFromQueryVals(queryVals)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	page, paramErr := getPageFromQueryVals(queryVals, r.URL)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	fs, unsupported := getFieldSelectionFromQueryVals(queryVals)
+	if unsupported != "" {
+		sendParamError(w, newParamError("fields", "Unsupported field: %s", unsupported))
+		return
+	}
+	whereExact, paramErr := extractSearchParams(queryVals, ExactParams)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
 	queryBuilder := &srm.QueryBuilder{
-		Db:         global.Db,
+		Db:         dbOf(r),
 		Table:      "vehicles",
+		Columns:    fs.Columns(),
 		Limit:      page.PageLength,
 		Offset:     page.PageLength * (page.PageNo - 1),
-		WhereExact: extractSearchParams(queryVals, ExactParams),
+		WhereExact: whereExact,
 		WhereFuzzy: extractStringParams(queryVals, FuzzyParams),
+		WhereIn:    map[string][]interface{}{"status": getStatusesFromQueryVals(queryVals)},
+	}
+	facets, unsupported := getFacetsFromQueryVals(queryVals)
+	if unsupported != "" {
+		sendParamError(w, newParamError("facets", "Unsupported facet: %s", unsupported))
+		return
+	}
+	format, isExport, unsupported := getExportFormat(queryVals, r.Header)
+	if unsupported != "" {
+		sendParamError(w, newParamError("format", "Unsupported format: %s", unsupported))
+		return
+	}
+	if isExport {
+		// Exports have fixed flat columns rather than sparse fieldsets
+		queryBuilder.Columns = nil
+	}
+
+	w.Header().Add("Vary", "Accept")
+	cacheKey, generation := normalisedQuery(r, profile), cache.Responses.Generation()
+	if !isExport && sendCachedResponse(w, r, cacheKey) {
+		return
+	}
+
+	// Vehicles only change with the dataset version, so it stands in for
+	// their update times
+	version, err := workers.GetDatasetVersion()
+	if checkErr(err, w) {
+		return
+	}
+	fp := getMostRecentFuelPrices()
+	etag := makeETag(version.ID, fp.ID, format, cacheKey)
+	lastModified := latest(fp.Updated, version.CreatedAt)
+	if checkNotModified(w, r, etag, lastModified) {
+		return
 	}
 
 	// Get results count
 	query, vals := queryBuilder.BuildCount()
-	resultCount, err := global.Db.SelectInt(query, vals...)
-	checkErr(err, w)
+	resultCount, err := dbOf(r).SelectInt(query, vals...)
+	if checkErr(err, w) {
+		return
+	}
 	page.Fill(queryVals, resultCount)
 
+	// Get counts per facet value over all results
+	facetCounts, err := selectFacets(queryBuilder, facets)
+	if checkErr(err, w) {
+		return
+	}
+
 	// Query for page of vehicles
-	query, vals = queryBuilder.BuildSelect()
+	vs, err := selectVehicles(r.Context(), queryBuilder, profile, fs.emissions && !isExport)
+	if checkErr(err, w) {
+		return
+	}
+	if isExport {
+		sendExport(w, format, vs)
+		return
+	}
+	out, err := fs.ApplyAll(vs)
+	if checkErr(err, w) {
+		return
+	}
+
+	// Send response
+	js, err := json.Marshal(VehiclesResponse{*page, profile, facetCounts, out})
+	if checkErr(err, w) {
+		return
+	}
+	cache.Responses.Add(cacheKey, generation, cache.Response{Body: js, ETag: etag, LastModified: lastModified})
+	sendJSON(w, js)
+}
+
+// Selects a page of vehicles, calculating their fuel data and optionally
+// attaching their emissions info
+func selectVehicles(ctx context.Context, queryBuilder *srm.QueryBuilder, profile models.DrivingProfile,
+	includeEmissions bool) ([]models.Vehicle, error) {
+	query, vals := queryBuilder.BuildSelect()
 	vs := make([]models.Vehicle, 0)
-	err = global.Db.SelectMany(&vs, query, vals...)
-	checkErr(err, w)
+	err := queryBuilder.Db.SelectMany(&vs, query, vals...)
+	if err != nil {
+		return vs, err
+	}
 
-	// Calculate fuel data on vehicles
+	// Calculate fuel data on vehicles. The span is kept for an empty page so
+	// every page's trace has the same shape.
+	_, span := tracing.Start(ctx, "calculate fuel data", attribute.Int("vehicles", len(vs)))
+	if len(vs) == 0 {
+		span.End()
+		return vs, nil
+	}
 	fp := getMostRecentFuelPrices()
 	epaIdsQuery, epaIds, epaIdToIdx := calculateFuelDataForAndCollectEpaIdsFromVehicles(
 		&vs, profile, fp)
+	span.End()
+	if !includeEmissions {
+		return vs, nil
+	}
 
 	// Query for emissions info and append to vehicles
 	eis := make([]models.EmissionsInfo, 0)
 	query = fmt.Sprintf("SELECT * FROM emissions_info WHERE epa_id IN (%s)", epaIdsQuery)
-	global.Db.SelectMany(&eis, query, epaIds...)
+	queryBuilder.Db.SelectMany(&eis, query, epaIds...)
 	for _, ei := range eis {
 		v := &vs[epaIdToIdx[ei.EpaID]]
 		v.EmissionsInfo = append(v.EmissionsInfo, ei)
 	}
 
-	// Send response
-	js, err := json.Marshal(VehiclesResponse{*page, profile, vs})
-	checkErr(err, w)
-	sendJSON(w, js)
+	return vs, nil
 }
 
 func calculateFuelDataForAndCollectEpaIdsFromVehicles(vehicles *[]models.Vehicle,
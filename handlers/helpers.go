@@ -1,23 +1,36 @@
 package handlers
 
 import (
-	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 
+	"github.com/teasherm/fueleconomy/cache"
 	"github.com/teasherm/fueleconomy/global"
 	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
 )
 
 // Error check helper
 
-func checkErr(err error, w http.ResponseWriter) {
-	if err != nil {
-		global.Logger.Println("Error: ", err)
+// Sends an error response if err isn't nil, with the status its srm error
+// kind maps to. Returns whether it did, in which case the handler should stop.
+func checkErr(err error, w http.ResponseWriter) bool {
+	if err == nil {
+		return false
+	}
+	responseLogger(w).Error("Request failed", "error", err)
+	switch srm.ErrorKind(err) {
+	case srm.ErrNotFound:
+		sendErrorJSON(w, "Not found", http.StatusNotFound)
+	case srm.ErrUniqueViolation, srm.ErrForeignKeyViolation:
+		sendErrorJSON(w, "Conflict", http.StatusConflict)
+	case srm.ErrConnection:
+		sendErrorJSON(w, "Database unavailable", http.StatusServiceUnavailable)
+	default:
 		sendErrorJSON(w, "Server error", http.StatusInternalServerError)
-		return
 	}
+	return true
 }
 
 // Search param data struct and parser
@@ -31,17 +44,23 @@ func intConverter(in string) (interface{}, error) {
 	return strconv.Atoi(in)
 }
 
-func extractSearchParams(queryVals url.Values, params []searchParam) map[string]interface{} {
+func stringConverter(in string) (interface{}, error) {
+	return in, nil
+}
+
+func extractSearchParams(queryVals url.Values, params []searchParam) (map[string]interface{}, *ParamError) {
 	out := make(map[string]interface{})
 	for _, param := range params {
 		val := queryVals.Get(param.name)
 		if val != "" {
-			if conv, err := param.converter(val); err != nil {
-				out[param.name] = conv
+			conv, err := param.converter(val)
+			if err != nil {
+				return out, newParamError(param.name, "Invalid %s: %s", param.name, val)
 			}
+			out[param.name] = conv
 		}
 	}
-	return out
+	return out, nil
 }
 
 func extractStringParams(queryVals url.Values, params []string) map[string]string {
@@ -57,50 +76,97 @@ func extractStringParams(queryVals url.Values, params []string) map[string]strin
 
 // Driving profile parser
 
-func getIntFromQueryVals(queryVals url.Values, param string) int {
+// Zero if the parameter isn't given
+func getIntFromQueryVals(queryVals url.Values, param string) (int, *ParamError) {
 	value := queryVals.Get(param)
-	if parsed, err := strconv.Atoi(value); err != nil {
-		return 0
-	} else {
-		return parsed
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, newParamError(param, "%s must be an integer: %s", param, value)
+	}
+	return parsed, nil
+}
+
+// The default if the parameter isn't given
+func getBoundedIntFromQueryVals(queryVals url.Values, param string, def int, min int, max int) (int, *ParamError) {
+	if queryVals.Get(param) == "" {
+		return def, nil
+	}
+	value, err := getIntFromQueryVals(queryVals, param)
+	if err != nil {
+		return def, err
+	}
+	if value < min || value > max {
+		return def, newParamError(param, "%s must be between %d and %d", param, min, max)
 	}
+	return value, nil
 }
 
-func getProfileFromQueryVals(queryVals url.Values) models.DrivingProfile {
+// Shares are percentages summing to 100. Given one, the other is what's left.
+func getProfileFromQueryVals(queryVals url.Values) (models.DrivingProfile, *ParamError) {
 	profile := models.DrivingProfile{
 		CityShare:    models.CityShareDefault,
 		HighwayShare: models.HighwayShareDefault,
 		MilesPerYear: models.MilesPerYearDefault,
 	}
 
-	cityShare := getIntFromQueryVals(queryVals, "cityShare")
-	if cityShare > 0 {
-		profile.CityShare = cityShare
+	for _, param := range []string{"cityShare", "highwayShare"} {
+		share, err := getIntFromQueryVals(queryVals, param)
+		if err != nil {
+			return profile, err
+		}
+		if share < 0 || share > 100 {
+			return profile, newParamError(param, "%s must be between 0 and 100", param)
+		}
 	}
-
-	highwayShare := getIntFromQueryVals(queryVals, "highwayShare")
-	if highwayShare > 0 {
-		profile.HighwayShare = highwayShare
+	cityShare, _ := getIntFromQueryVals(queryVals, "cityShare")
+	highwayShare, _ := getIntFromQueryVals(queryVals, "highwayShare")
+	hasCity, hasHighway := queryVals.Get("cityShare") != "", queryVals.Get("highwayShare") != ""
+	switch {
+	case hasCity && hasHighway:
+		if cityShare+highwayShare != 100 {
+			return profile, newParamError("highwayShare", "cityShare and highwayShare must sum to 100")
+		}
+		profile.CityShare, profile.HighwayShare = cityShare, highwayShare
+	case hasCity:
+		profile.CityShare, profile.HighwayShare = cityShare, 100-cityShare
+	case hasHighway:
+		profile.CityShare, profile.HighwayShare = 100-highwayShare, highwayShare
 	}
 
-	milesPerYear := getIntFromQueryVals(queryVals, "milesPerYear")
-	if milesPerYear > 0 {
+	milesPerYear, err := getIntFromQueryVals(queryVals, "milesPerYear")
+	if err != nil {
+		return profile, err
+	}
+	if queryVals.Get("milesPerYear") != "" {
+		if milesPerYear < models.MilesPerYearMin || milesPerYear > models.MilesPerYearMax {
+			return profile, newParamError("milesPerYear", "milesPerYear must be between %d and %d",
+				models.MilesPerYearMin, models.MilesPerYearMax)
+		}
 		profile.MilesPerYear = milesPerYear
 	}
 
-	return profile
+	return profile, nil
 }
 
 // Fuel prices retriever
 
-func getMostRecentFuelPrices() (fp models.FuelPrices) {
-	query := "SELECT * FROM fuel_prices WHERE updated = (SELECT MAX(updated) from fuel_prices)"
-	global.Db.SelectOne(&fp, query)
-	return fp
+// Vehicles withdrawn from the EPA feed are only listed if asked for, and
+// deleted ones never are
+func getStatusesFromQueryVals(queryVals url.Values) []interface{} {
+	if queryVals.Get("includeWithdrawn") == "true" {
+		return []interface{}{models.VehicleActive, models.VehicleWithdrawn}
+	}
+	return []interface{}{models.VehicleActive}
 }
 
-// Get maximum of two integers
-
-func maxInt(first int, second int) int {
-	return int(math.Max(float64(first), float64(second)))
+// Zero prices if none have been ingested or they can't be loaded
+func getMostRecentFuelPrices() models.FuelPrices {
+	fp, err := cache.CurrentFuelPrices()
+	if err != nil && srm.ErrorKind(err) != srm.ErrNotFound {
+		global.Logger.Error("Fuel prices not loaded", "error", err)
+	}
+	return fp
 }
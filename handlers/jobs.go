@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+	"github.com/teasherm/fueleconomy/workers"
+)
+
+func JobGetOne(w http.ResponseWriter, r *http.Request) {
+	job, ok := getJob(w, r)
+	if !ok {
+		return
+	}
+
+	js, err := json.Marshal(JobResponse{job})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Data quality reports of an ingest job, one per dataset checked
+func JobGetQuality(w http.ResponseWriter, r *http.Request) {
+	job, ok := getJob(w, r)
+	if !ok {
+		return
+	}
+	reports, err := workers.GetQualityReports(job.ID)
+	if checkErr(err, w) {
+		return
+	}
+
+	js, err := json.Marshal(QualityResponse{job, global.QualityAbortThreshold, reports})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Changes a dry run would make, filterable by dataset and change type
+func JobGetDiff(w http.ResponseWriter, r *http.Request) {
+	job, ok := getJob(w, r)
+	if !ok {
+		return
+	}
+	queryVals := r.URL.Query()
+	page, paramErr := getPageFromQueryVals(queryVals, r.URL)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	where := map[string]interface{}{"job_id": job.ID}
+	if dataset := queryVals.Get("dataset"); dataset != "" {
+		where["dataset"] = dataset
+	}
+	if change := queryVals.Get("change"); change != "" {
+		where["change_type"] = change
+	}
+	queryBuilder := &srm.QueryBuilder{
+		Db:         dbOf(r),
+		Table:      "ingest_changes",
+		Columns:    []string{"id", "dataset", "epa_id", "change_type", "fields"},
+		Limit:      page.PageLength,
+		Offset:     page.PageLength * (page.PageNo - 1),
+		WhereExact: where,
+	}
+
+	query, vals := queryBuilder.BuildCount()
+	resultCount, err := dbOf(r).SelectInt(query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+	page.Fill(queryVals, resultCount)
+
+	summary, err := selectDiffSummary(r.Context(), job.ID)
+	if checkErr(err, w) {
+		return
+	}
+
+	query, vals = queryBuilder.BuildSelect()
+	changes := make([]models.IngestChange, 0)
+	err = dbOf(r).SelectMany(&changes, query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+	for i := range changes {
+		err = changes[i].FillFields()
+		if checkErr(err, w) {
+			return
+		}
+	}
+
+	js, err := json.Marshal(DiffResponse{*page, job, summary, changes})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Counts a dry run's changes per dataset and change type
+func selectDiffSummary(ctx context.Context, jobID int) (map[string]map[string]int, error) {
+	summary := map[string]map[string]int{
+		"vehicles":  map[string]int{},
+		"emissions": map[string]int{},
+	}
+	queryBuilder := &srm.QueryBuilder{
+		Db:         global.Db.WithContext(ctx),
+		Table:      "ingest_changes",
+		WhereExact: map[string]interface{}{"job_id": jobID},
+	}
+	query, vals := queryBuilder.BuildGroupBy("dataset || ':' || change_type", "COUNT(*) AS count")
+	counts := make([]models.FacetCount, 0)
+	err := queryBuilder.Db.SelectMany(&counts, query, vals...)
+	if err != nil {
+		return summary, err
+	}
+	for _, count := range counts {
+		parts := strings.SplitN(count.Value, ":", 2)
+		if _, ok := summary[parts[0]]; ok && len(parts) == 2 {
+			summary[parts[0]][parts[1]] = count.Count
+		}
+	}
+	return summary, nil
+}
+
+// Applies the changes of a dry run awaiting approval
+func JobApprove(w http.ResponseWriter, r *http.Request) {
+	job, ok := getJob(w, r)
+	if !ok {
+		return
+	}
+
+	// Audited with the approval, so neither is recorded without the other
+	var work workers.WorkRequest
+	err := dbOf(r).Transaction(nil, func(tx *srm.DbMap) error {
+		var err error
+		work, err = workers.GenerateApprovalWorkRequest(tx, job.ID)
+		if err != nil {
+			return err
+		}
+		return audit(tx, r, "approve", job.Target, work.Job.ID)
+	})
+	if err == workers.ErrNotAwaitingApproval || err == workers.ErrDryRunStale ||
+		err == workers.ErrIngestPending {
+		sendErrorJSON(w, err.Error(), http.StatusConflict)
+		return
+	} else if checkErr(err, w) {
+		return
+	}
+	workers.WorkQueue <- work
+
+	js, err := json.Marshal(IngestResponse{fmt.Sprintf("Approval kicked off for job: %d", job.ID), work.Job})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Sends a 404 if the job doesn't exist
+func getJob(w http.ResponseWriter, r *http.Request) (*models.Job, bool) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	job, err := workers.GetJob(id)
+	if srm.ErrorKind(err) == srm.ErrNotFound {
+		sendErrorJSON(w, fmt.Sprintf("Job not found: %d", id), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		checkErr(err, w)
+		return nil, false
+	}
+	return job, true
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/teasherm/fueleconomy/auth"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+type keyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Plan   string   `json:"plan"`
+}
+
+// Issues a key from a JSON body ({"name": "...", "scopes": ["read"], "plan":
+// "free"}). The key is only ever in this response.
+func KeyCreate(w http.ResponseWriter, r *http.Request) {
+	var kr keyRequest
+	err := json.NewDecoder(r.Body).Decode(&kr)
+	if err != nil {
+		sendErrorJSON(w, "Request body must be JSON: {\"name\": ..., \"scopes\": [...], \"plan\": ...}",
+			http.StatusBadRequest)
+		return
+	}
+	if kr.Plan == "" {
+		kr.Plan = models.PlanDefault
+	}
+	err = auth.ValidateKey(kr.Name, kr.Scopes, kr.Plan)
+	if err != nil {
+		sendErrorJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if checkErr(audit(dbOf(r), r, "create_key", kr.Name, 0), w) {
+		return
+	}
+	key, apiKey, err := auth.CreateKey(kr.Name, kr.Scopes, kr.Plan)
+	if checkErr(err, w) {
+		return
+	}
+
+	js, err := json.Marshal(KeyResponse{key, apiKey})
+	if checkErr(err, w) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(js)
+}
+
+func KeysGet(w http.ResponseWriter, r *http.Request) {
+	queryVals := r.URL.Query()
+	page, paramErr := getPageFromQueryVals(queryVals, r.URL)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	keys, total, err := auth.GetKeys(page.PageLength, page.PageLength*(page.PageNo-1))
+	if checkErr(err, w) {
+		return
+	}
+	page.Fill(queryVals, total)
+
+	js, err := json.Marshal(KeysResponse{*page, keys})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Revoked keys are kept, and refused from then on
+func KeyRevoke(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	if checkErr(audit(dbOf(r), r, "revoke_key", fmt.Sprintf("key/%d", id), 0), w) {
+		return
+	}
+	apiKey, err := auth.RevokeKey(id)
+	if srm.ErrorKind(err) == srm.ErrNotFound {
+		sendErrorJSON(w, fmt.Sprintf("Key not found: %d", id), http.StatusNotFound)
+		return
+	} else if checkErr(err, w) {
+		return
+	}
+
+	js, err := json.Marshal(KeyResponse{APIKey: apiKey})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// A key's request counts by day, most recent first
+func KeyGetUsage(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	apiKey, err := auth.GetKey(id)
+	if srm.ErrorKind(err) == srm.ErrNotFound {
+		sendErrorJSON(w, fmt.Sprintf("Key not found: %d", id), http.StatusNotFound)
+		return
+	} else if checkErr(err, w) {
+		return
+	}
+	err = auth.FlushUsage()
+	if checkErr(err, w) {
+		return
+	}
+	usage, err := auth.GetUsage(id)
+	if checkErr(err, w) {
+		return
+	}
+
+	js, err := json.Marshal(UsageResponse{apiKey, usage})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
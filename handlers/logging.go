@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/logging"
+)
+
+// The request's logger, which adds its request ID to every line
+func loggerOf(r *http.Request) *slog.Logger {
+	if logger := logging.FromContext(r.Context()); logger != nil {
+		return logger
+	}
+	return global.Logger
+}
+
+// For helpers that only have the response, which carries the request ID
+func responseLogger(w http.ResponseWriter) *slog.Logger {
+	return global.Logger.With("request_id", w.Header().Get(RequestIDHeader))
+}
+
+// Logs every request once it's served, with its status and latency
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		loggerOf(r).Info("Request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"query", r.URL.RawQuery,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"latency_ms", float64(time.Since(start).Microseconds())/1000.0,
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent())
+	})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"math"
 	"net/url"
 	"strconv"
 )
@@ -12,22 +13,23 @@ var (
 	PageNoDefault     int = 1
 )
 
-func getPageFromQueryVals(queryVals url.Values, URL *url.URL) (p *PageInfo) {
-	p = &PageInfo{
+func getPageFromQueryVals(queryVals url.Values, URL *url.URL) (*PageInfo, *ParamError) {
+	p := &PageInfo{
 		BaseUrl:    URL,
 		PageLength: PageLengthDefault,
 		PageNo:     PageNoDefault,
 	}
-	pageNo := getIntFromQueryVals(queryVals, "page")
-	pageLength := getIntFromQueryVals(queryVals, "pageLength")
-	if pageNo > 0 {
-		p.PageNo = pageNo
+	pageNo, err := getBoundedIntFromQueryVals(queryVals, "page", PageNoDefault, 1, math.MaxInt32)
+	if err != nil {
+		return p, err
 	}
-	if pageLength > 0 {
-		p.PageLength = maxInt(pageLength, PageLengthMax)
+	pageLength, err := getBoundedIntFromQueryVals(queryVals, "pageLength", PageLengthDefault, 1, PageLengthMax)
+	if err != nil {
+		return p, err
 	}
+	p.PageNo, p.PageLength = pageNo, pageLength
 
-	return p
+	return p, nil
 }
 
 type PageInfo struct {
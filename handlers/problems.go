@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/logging"
+)
+
+const RequestIDHeader string = "X-Request-ID"
+
+// Machine readable problem codes, by the status they're sent with unless a
+// more specific one applies
+var ProblemCodes map[int]string = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusInternalServerError:   "server_error",
+}
+
+// RFC 7807 problem details, the body of every error response
+type Problem struct {
+	Type      string `json:"type"`            // Always about:blank, the code says what went wrong
+	Title     string `json:"title"`           // The status text
+	Status    int    `json:"status"`          // The HTTP status
+	Code      string `json:"code"`            // Machine readable, e.g. invalid_parameter
+	Detail    string `json:"detail"`          // Human readable message
+	Param     string `json:"param,omitempty"` // The query parameter that failed validation
+	RequestID string `json:"requestId"`       // Also sent as the X-Request-ID header
+}
+
+// An invalid query parameter, sent as a 400 invalid_parameter problem
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return e.Message
+}
+
+func newParamError(param string, format string, args ...interface{}) *ParamError {
+	return &ParamError{param, fmt.Sprintf(format, args...)}
+}
+
+// Tags every request with an ID that error responses and log lines carry, so
+// a report of one can be found in the logs. A well formed ID sent by a client
+// or proxy is kept, so a request can be followed across services.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		logger := global.Logger.With("request_id", requestID)
+		next.ServeHTTP(w, r.WithContext(logging.NewContext(r.Context(), logger)))
+	})
+}
+
+var validRequestID *regexp.Regexp = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sendProblem(w http.ResponseWriter, problem Problem) {
+	problem.Type = "about:blank"
+	problem.Title = http.StatusText(problem.Status)
+	problem.RequestID = w.Header().Get(RequestIDHeader)
+	js, _ := json.Marshal(problem)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	w.Write(js)
+}
+
+func sendParamError(w http.ResponseWriter, err *ParamError) {
+	sendProblem(w, Problem{
+		Status: http.StatusBadRequest,
+		Code:   "invalid_parameter",
+		Detail: err.Message,
+		Param:  err.Param,
+	})
+}
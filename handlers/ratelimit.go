@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/teasherm/fueleconomy/auth"
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/ratelimit"
+)
+
+// Paths anonymous requests to aren't limited, as Prometheus scrapes
+// /metrics without a key
+var RateLimitExemptPaths map[string]bool = map[string]bool{"/metrics": true}
+
+// Limits requests per key by the key's plan, and anonymous requests per
+// address by the anonymous plan, counting each towards the day's usage. If
+// the limiter's store fails, requests are let through rather than refused.
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, planName, keyID := anonymousBucket(r), models.PlanAnonymous, 0
+		if apiKey := apiKeyOf(r); apiKey != nil {
+			bucket, planName, keyID = "key:"+strconv.Itoa(apiKey.ID), apiKey.Plan, apiKey.ID
+		} else if RateLimitExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if takeToken(w, r, bucket, planName, keyID) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Spends a token from the bucket and sets the rate limit headers, sending a
+// 429 and returning false if it's empty
+func takeToken(w http.ResponseWriter, r *http.Request, bucket string, planName string, keyID int) bool {
+	now := time.Now()
+	plan, ok := global.RateLimitPlans[planName]
+	if !ok {
+		plan = global.RateLimitPlans[models.PlanDefault]
+	}
+
+	result, err := ratelimit.Limiter.Take(bucket, plan, now)
+	if err != nil {
+		loggerOf(r).Error("Rate limit store failed", "error", err)
+		return true
+	}
+	auth.RecordUsage(keyID, now)
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(result.Reset.Unix(), 10))
+	if !result.Allowed {
+		retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		sendErrorJSON(w, "Rate limit exceeded, retry in "+strconv.Itoa(retryAfter)+"s",
+			http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// The bucket anonymous requests from the request's address share
+func anonymousBucket(r *http.Request) string {
+	return "anon:" + remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,9 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 
+	"github.com/teasherm/fueleconomy/cache"
 	"github.com/teasherm/fueleconomy/models"
 )
 
@@ -11,22 +11,129 @@ type SimpleResponse struct {
 	Message string `json:"message"`
 }
 
+type StatsGroup struct {
+	Group   string                        `json:"group"`
+	Metrics map[string]models.VehicleStat `json:"metrics"`
+}
+
+type StatsResponse struct {
+	GroupBy string       `json:"groupBy"`
+	Groups  []StatsGroup `json:"groups"`
+}
+
+type TrendsResponse struct {
+	Make   string              `json:"make,omitempty"`
+	Model  string              `json:"model,omitempty"`
+	Series []models.TrendPoint `json:"series"`
+}
+
+// Vehicles are models.Vehicle, or maps when a sparse fieldset was requested
 type VehicleResponse struct {
 	Profile models.DrivingProfile `json:"profile"`
-	Vehicle models.Vehicle        `json:"vehicle"`
+	Vehicle interface{}           `json:"vehicle"`
 }
 
 type VehiclesResponse struct {
+	Meta     PageInfo                       `json:"meta"`
+	Profile  models.DrivingProfile          `json:"profile"`
+	Facets   map[string][]models.FacetCount `json:"facets,omitempty"`
+	Vehicles []interface{}                  `json:"vehicles"`
+}
+
+type AlternativesResponse struct {
+	Profile      models.DrivingProfile `json:"profile"`
+	RankBy       string                `json:"rankBy"`
+	Vehicle      models.Vehicle        `json:"vehicle"`
+	Alternatives []models.Alternative  `json:"alternatives"`
+}
+
+type AutocompleteResponse struct {
+	Suggestions []models.Suggestion `json:"suggestions"`
+}
+
+type BatchResponse struct {
+	Profile  models.DrivingProfile `json:"profile"`
+	Vehicles []models.Vehicle      `json:"vehicles"`
+	NotFound []int                 `json:"notFound"`
+}
+
+type MakesResponse struct {
+	Makes []string `json:"makes"`
+}
+
+type ModelsResponse struct {
+	Models []string `json:"models"`
+}
+
+type OptionsResponse struct {
+	Options []models.VehicleOption `json:"options"`
+}
+
+type SearchResponse struct {
 	Meta     PageInfo              `json:"meta"`
 	Profile  models.DrivingProfile `json:"profile"`
+	Terms    [][]string            `json:"terms"`
 	Vehicles []models.Vehicle      `json:"vehicles"`
 }
 
+type YearsResponse struct {
+	Years []int `json:"years"`
+}
+
+type AuditResponse struct {
+	Meta    PageInfo            `json:"meta"`
+	Entries []models.AuditEntry `json:"entries"`
+}
+
+type KeyResponse struct {
+	Key    string         `json:"key,omitempty"`
+	APIKey *models.APIKey `json:"apiKey"`
+}
+
+type KeysResponse struct {
+	Meta PageInfo        `json:"meta"`
+	Keys []models.APIKey `json:"keys"`
+}
+
+type UsageResponse struct {
+	APIKey *models.APIKey `json:"apiKey"`
+	Usage  []models.Usage `json:"usage"`
+}
+
+type CacheResponse struct {
+	Responses  cache.Stats `json:"responses"`
+	FuelPrices cache.Stats `json:"fuelPrices"`
+}
+
+type IngestResponse struct {
+	Message string      `json:"message"`
+	Job     *models.Job `json:"job"`
+}
+
+type JobResponse struct {
+	Job *models.Job `json:"job"`
+}
+
+type DiffResponse struct {
+	Meta    PageInfo                  `json:"meta"`
+	Job     *models.Job               `json:"job"`
+	Summary map[string]map[string]int `json:"summary"` // Change counts per dataset and change type
+	Changes []models.IngestChange     `json:"changes"`
+}
+
+type QualityResponse struct {
+	Job       *models.Job            `json:"job"`
+	Threshold float64                `json:"threshold"` // Share of violating records that aborts an ingest
+	Reports   []models.QualityReport `json:"reports"`
+}
+
+type SnapshotsResponse struct {
+	Snapshots []models.SnapshotManifest `json:"snapshots"`
+}
+
+// Sends a problem with the code for its status, see ProblemCodes
 func sendErrorJSON(w http.ResponseWriter, message string, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	js, _ := json.Marshal(SimpleResponse{message})
-	w.WriteHeader(code)
-	w.Write(js)
+	sendProblem(w, Problem{Status: code, Code: ProblemCodes[code], Detail: message})
 }
 
 func sendJSON(w http.ResponseWriter, payload []byte) {
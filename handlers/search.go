@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+// Full text search over make, model, base model, engine and transmission
+// descriptions, ranked by relevance. Accepts the same exact, driving profile
+// and pagination parameters as VehicleGetMany. A query without any words is
+// refused rather than matching every vehicle.
+func VehicleSearch(w http.ResponseWriter, r *http.Request) {
+	queryVals := r.URL.Query()
+	if len(models.SearchTokens(queryVals.Get("q"))) == 0 {
+		sendParamError(w, newParamError("q", "Missing required parameter: q"))
+		return
+	}
+	profile, paramErr := getProfileFromQueryVals(queryVals)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	page, paramErr := getPageFromQueryVals(queryVals, r.URL)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	textSearch, err := getTextSearchFromQueryVals(r.Context(), queryVals)
+	if checkErr(err, w) {
+		return
+	}
+	whereExact, paramErr := extractSearchParams(queryVals, ExactParams)
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	queryBuilder := &srm.QueryBuilder{
+		Db:         dbOf(r),
+		Table:      "vehicles",
+		Limit:      page.PageLength,
+		Offset:     page.PageLength * (page.PageNo - 1),
+		WhereExact: whereExact,
+		WhereIn:    map[string][]interface{}{"status": getStatusesFromQueryVals(queryVals)},
+		TextSearch: textSearch,
+	}
+
+	// Get results count
+	query, vals := queryBuilder.BuildCount()
+	resultCount, err := dbOf(r).SelectInt(query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+	page.Fill(queryVals, resultCount)
+
+	// Query for page of vehicles, most relevant first
+	vs, err := selectVehicles(r.Context(), queryBuilder, profile, true)
+	if checkErr(err, w) {
+		return
+	}
+
+	// Send response
+	js, err := json.Marshal(SearchResponse{*page, profile, textSearch.Terms, vs})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+func getTextSearchFromQueryVals(ctx context.Context, queryVals url.Values) (*srm.TextSearch, error) {
+	tokens := models.SearchTokens(queryVals.Get("q"))
+	ts := &srm.TextSearch{
+		Document: "search_document",
+		Query:    strings.Join(tokens, " "),
+		Terms:    make([][]string, 0),
+	}
+	for _, token := range tokens {
+		alternatives, err := getSearchTermAlternatives(ctx, token)
+		if err != nil {
+			return ts, err
+		}
+		ts.Terms = append(ts.Terms, alternatives)
+	}
+	return ts, nil
+}
+
+// Tokens that don't prefix any known search term are widened to the known
+// terms within typo distance of them
+func getSearchTermAlternatives(ctx context.Context, token string) ([]string, error) {
+	alternatives := []string{token}
+	maxTypos := models.MaxTypos(token)
+	if maxTypos == 0 {
+		return alternatives, nil
+	}
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM search_terms WHERE term LIKE %s",
+		global.Db.Dialect.Placeholder(1))
+	matches, err := global.Db.WithContext(ctx).SelectInt(query, token+"%")
+	if err != nil || matches > 0 {
+		return alternatives, err
+	}
+
+	terms := make([]models.SearchTerm, 0)
+	query = fmt.Sprintf("SELECT * FROM search_terms WHERE length(term) BETWEEN %s AND %s",
+		global.Db.Dialect.Placeholder(1), global.Db.Dialect.Placeholder(2))
+	length := len([]rune(token))
+	err = global.Db.WithContext(ctx).SelectMany(&terms, query, length-maxTypos, length+maxTypos)
+	if err != nil {
+		return alternatives, err
+	}
+	for _, term := range terms {
+		if models.EditDistance(token, term.Term) <= maxTypos {
+			alternatives = append(alternatives, term.Term)
+		}
+	}
+	return alternatives, nil
+}
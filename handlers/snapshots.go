@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/workers"
+)
+
+// Lists dataset snapshots, newest first
+func SnapshotsGet(w http.ResponseWriter, r *http.Request) {
+	manifests, err := workers.ReadSnapshotManifests()
+	if checkErr(err, w) {
+		return
+	}
+	for i := range manifests {
+		fillSnapshotUrls(&manifests[i])
+	}
+
+	js, err := json.Marshal(SnapshotsResponse{manifests})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Manifest of one snapshot, or "latest"
+func SnapshotGetOne(w http.ResponseWriter, r *http.Request) {
+	manifest, ok := getSnapshotManifest(w, mux.Vars(r)["version"])
+	if !ok {
+		return
+	}
+	fillSnapshotUrls(&manifest)
+
+	js, err := json.Marshal(manifest)
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Downloads a file listed in a snapshot's manifest
+func SnapshotGetFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	manifest, ok := getSnapshotManifest(w, vars["version"])
+	if !ok {
+		return
+	}
+	file, ok := manifest.File(vars["file"])
+	if !ok {
+		sendErrorJSON(w, fmt.Sprintf("Snapshot file not found: %s", vars["file"]), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s"`,
+		manifest.Version, file.Name))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, workers.SnapshotFilePath(manifest.Version, file.Name))
+}
+
+// Sends a 404 if the snapshot doesn't exist
+func getSnapshotManifest(w http.ResponseWriter, version string) (models.SnapshotManifest, bool) {
+	var manifest models.SnapshotManifest
+	var err error
+	if version == "latest" {
+		var manifests []models.SnapshotManifest
+		manifests, err = workers.ReadSnapshotManifests()
+		if err == nil && len(manifests) == 0 {
+			err = os.ErrNotExist
+		} else if err == nil {
+			manifest = manifests[0]
+		}
+	} else {
+		manifest, err = workers.ReadSnapshotManifest(version)
+	}
+	if os.IsNotExist(err) {
+		sendErrorJSON(w, fmt.Sprintf("Snapshot not found: %s", version), http.StatusNotFound)
+		return manifest, false
+	} else if err != nil {
+		checkErr(err, w)
+		return manifest, false
+	}
+	return manifest, true
+}
+
+func fillSnapshotUrls(manifest *models.SnapshotManifest) {
+	for i := range manifest.Files {
+		manifest.Files[i].Url = fmt.Sprintf("/snapshots/%s/%s", manifest.Version, manifest.Files[i].Name)
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+)
+
+var StatsGroupByDefault string = "year"
+
+// Precomputed min/max/mean/median/percentile statistics of MPG, CO2 and
+// annual fuel cost, grouped by year, sizeClass, make or fuelType. Optionally
+// narrowed to one group and one metric.
+func StatsGet(w http.ResponseWriter, r *http.Request) {
+	queryVals := r.URL.Query()
+	groupBy := queryVals.Get("groupBy")
+	if groupBy == "" {
+		groupBy = StatsGroupByDefault
+	}
+	if _, ok := models.StatisticsGroupings[groupBy]; !ok {
+		sendParamError(w, newParamError("groupBy", "Unsupported groupBy: %s", groupBy))
+		return
+	}
+	metric := queryVals.Get("metric")
+	if _, ok := models.StatisticsMetrics[metric]; metric != "" && !ok {
+		sendParamError(w, newParamError("metric", "Unsupported metric: %s", metric))
+		return
+	}
+
+	queryBuff := bytes.Buffer{}
+	queryBuff.WriteString(fmt.Sprintf("SELECT * FROM vehicle_stats WHERE group_by = %s",
+		global.Db.Dialect.Placeholder(1)))
+	args := []interface{}{groupBy}
+	if group := queryVals.Get("group"); group != "" {
+		args = append(args, group)
+		queryBuff.WriteString(fmt.Sprintf(" AND group_key = %s",
+			global.Db.Dialect.Placeholder(len(args))))
+	}
+	if metric != "" {
+		args = append(args, metric)
+		queryBuff.WriteString(fmt.Sprintf(" AND metric = %s",
+			global.Db.Dialect.Placeholder(len(args))))
+	}
+	queryBuff.WriteString(" ORDER BY group_key, metric")
+
+	stats := make([]models.VehicleStat, 0)
+	err := dbOf(r).SelectMany(&stats, queryBuff.String(), args...)
+	if checkErr(err, w) {
+		return
+	}
+
+	groups := make([]StatsGroup, 0)
+	for _, stat := range stats {
+		if len(groups) == 0 || groups[len(groups)-1].Group != stat.GroupKey {
+			groups = append(groups, StatsGroup{stat.GroupKey, make(map[string]models.VehicleStat)})
+		}
+		groups[len(groups)-1].Metrics[stat.Metric] = stat
+	}
+
+	js, err := json.Marshal(StatsResponse{groupBy, groups})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
+
+// Rankings are precomputed per ingest, so a vehicle ingested since has none
+func getVehicleRankings(ctx context.Context, epaId int) (*models.VehicleRanking, error) {
+	rankings := make([]models.VehicleRanking, 0)
+	query := fmt.Sprintf("SELECT * FROM vehicle_rankings WHERE epa_id = %s",
+		global.Db.Dialect.Placeholder(1))
+	err := global.Db.WithContext(ctx).SelectMany(&rankings, query, epaId)
+	if err != nil || len(rankings) == 0 {
+		return nil, err
+	}
+	return &rankings[0], nil
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/logging"
+	"github.com/teasherm/fueleconomy/srm"
+	"github.com/teasherm/fueleconomy/tracing"
+)
+
+// Serves each request in a span named by its route, continuing the trace in
+// its traceparent header if any. Its log lines carry the trace ID, so a trace
+// can be found from them and them from it.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.StartKind(ctx, trace.SpanKindServer, r.Method+" "+routeOf(r),
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", routeOf(r)),
+			attribute.String("http.target", r.URL.RequestURI()),
+			attribute.String("http.user_agent", r.UserAgent()),
+			attribute.String("request_id", w.Header().Get(RequestIDHeader)))
+		if sc := span.SpanContext(); sc.IsValid() {
+			ctx = logging.NewContext(ctx, loggerOf(r).With("trace_id", sc.TraceID().String(),
+				"span_id", sc.SpanID().String()))
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", rec.status),
+			attribute.Int("http.response_content_length", rec.bytes))
+		if rec.status >= 500 {
+			tracing.SetError(span, errorStatus(rec.status))
+		}
+		span.End()
+	})
+}
+
+type errorStatus int
+
+func (s errorStatus) Error() string {
+	return http.StatusText(int(s))
+}
+
+// The database, with its operations traced as part of the request
+func dbOf(r *http.Request) *srm.DbMap {
+	return global.Db.WithContext(r.Context())
+}
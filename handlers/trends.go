@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+var (
+	// Make and model are optional, leaving them out trends the whole market.
+	// They're exact matched, as the catalog lists them, so a series is one
+	// nameplate rather than every model sharing its prefix.
+	TrendParams []string = []string{"make", "model"}
+
+	TrendAggregates []string = []string{
+		"COUNT(*) AS count",
+		"COALESCE(MAX(NULLIF(f1_mpg_comb, 0)), 0) AS best_mpg",
+		"COALESCE(MIN(NULLIF(f1_mpg_comb, 0)), 0) AS worst_mpg",
+		"COALESCE(AVG(NULLIF(f1_mpg_comb, 0)), 0) AS avg_mpg",
+		"COALESCE(MIN(NULLIF(f1_co2_tailpipe, 0)), 0) AS best_co2",
+		"COALESCE(MAX(NULLIF(f1_co2_tailpipe, 0)), 0) AS worst_co2",
+		"COALESCE(AVG(NULLIF(f1_co2_tailpipe, 0)), 0) AS avg_co2",
+		"COALESCE(MIN(NULLIF(f1_fuel_cost, 0)), 0) AS best_fuel_cost",
+		"COALESCE(MAX(NULLIF(f1_fuel_cost, 0)), 0) AS worst_fuel_cost",
+		"COALESCE(AVG(NULLIF(f1_fuel_cost, 0)), 0) AS avg_fuel_cost",
+		"COALESCE(AVG(NULLIF(eng_displacement, 0)), 0) AS avg_eng_displacement",
+		"COALESCE(AVG(NULLIF(cylinders, 0)), 0) AS avg_cylinders",
+		fmt.Sprintf("SUM(CASE WHEN atv_type IN ('%s') THEN 1 ELSE 0 END) AS electrified_count",
+			strings.Join(models.ElectrifiedAtvTypes, "', '")),
+		"SUM(CASE WHEN has_turbocharger OR has_supercharger THEN 1 ELSE 0 END) AS forced_induction_count",
+	}
+)
+
+// Per model year series of best, worst and average efficiency for a make and
+// model, or for the whole market when neither is given. Zero values are
+// missing data and are left out of the aggregates.
+func TrendsGet(w http.ResponseWriter, r *http.Request) {
+	queryVals := r.URL.Query()
+	yearRange := srm.Range{}
+	from, paramErr := getIntFromQueryVals(queryVals, "from")
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	to, paramErr := getIntFromQueryVals(queryVals, "to")
+	if paramErr != nil {
+		sendParamError(w, paramErr)
+		return
+	}
+	if from > 0 {
+		yearRange.Min = from
+	}
+	if to > 0 {
+		yearRange.Max = to
+	}
+	whereExact := make(map[string]interface{})
+	for param, val := range extractStringParams(queryVals, TrendParams) {
+		whereExact[param] = val
+	}
+	queryBuilder := &srm.QueryBuilder{
+		Db:         dbOf(r),
+		Table:      "vehicles",
+		WhereExact: whereExact,
+		WhereIn:    map[string][]interface{}{"status": getStatusesFromQueryVals(queryVals)},
+		WhereRange: map[string]srm.Range{"year": yearRange},
+	}
+
+	query, vals := queryBuilder.BuildGroupBy("year", TrendAggregates...)
+	points := make([]models.TrendPoint, 0)
+	err := dbOf(r).SelectMany(&points, query, vals...)
+	if checkErr(err, w) {
+		return
+	}
+	for i := range points {
+		points[i].FillShares()
+	}
+
+	js, err := json.Marshal(TrendsResponse{
+		Make:   queryVals.Get("make"),
+		Model:  queryVals.Get("model"),
+		Series: points,
+	})
+	if checkErr(err, w) {
+		return
+	}
+	sendJSON(w, js)
+}
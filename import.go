@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/workers"
+)
+
+// Import targets run in order for "all". Vehicles ingest emissions too.
+var ImportTargets map[string][]string = map[string][]string{
+	"all":        []string{"fuelprices", "vehicles"},
+	"vehicles":   []string{"vehicles"},
+	"emissions":  []string{"emissions"},
+	"fuelprices": []string{"fuelprices"},
+}
+
+const importUsage string = "Usage: fueleconomy import [-target all|vehicles|emissions|fuelprices] [-dry-run] <path|->\n" +
+	"       fueleconomy import -approve <job id>"
+
+// Ingests from a local directory, zip archive or stdin instead of the EPA:
+//
+//	fueleconomy import [-target all|vehicles|emissions|fuelprices] [-dry-run] <path|->
+//	fueleconomy import -approve <job id>
+//
+// A dry run only records the changes it would make, which -approve applies.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	target := flags.String("target", "all", "What to import: all, vehicles, emissions or fuelprices")
+	dryRun := flags.Bool("dry-run", false, "Record the changes vehicles or emissions would make without applying them")
+	approve := flags.Int("approve", 0, "Apply the changes recorded by a dry run job")
+	flags.Parse(args)
+	if *approve > 0 {
+		return runApprove(*approve)
+	}
+	if flags.NArg() != 1 {
+		return errors.New(importUsage)
+	}
+	targets, ok := ImportTargets[*target]
+	if !ok {
+		return errors.New(fmt.Sprintf("Import target %s not valid", *target))
+	}
+	if *dryRun && *target != "vehicles" && *target != "emissions" {
+		return errors.New(fmt.Sprintf("Dry run not supported for target %s", *target))
+	}
+
+	fetcher := workers.NewLocalFetcher(flags.Arg(0))
+	for _, t := range targets {
+		work, err := workers.GenerateWorkRequest(global.Db, t, "", *dryRun)
+		if err != nil {
+			return err
+		}
+		work.Fetcher = fetcher
+		err = work.DoWork()
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			global.Logger.Info("Dry run awaiting approval", "job_id", work.Job.ID, "target", t)
+			return nil
+		}
+		global.Logger.Info("Import succeeded", "job_id", work.Job.ID, "target", t)
+	}
+
+	// Snapshots are only queued by the server, so make one here
+	return workers.CreateSnapshot(fetcher)
+}
+
+func runApprove(jobID int) error {
+	work, err := workers.GenerateApprovalWorkRequest(global.Db, jobID)
+	if err != nil {
+		return err
+	}
+	err = work.DoWork()
+	if err != nil {
+		return err
+	}
+	global.Logger.Info("Dry run applied", "job_id", work.Job.ID, "approves_job_id", jobID)
+	return workers.CreateSnapshot(nil)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/auth"
+	"github.com/teasherm/fueleconomy/models"
+)
+
+const keysUsage string = "Usage: fueleconomy keys create -name <name> -scopes read|ingest|admin[,...] [-plan <plan>]"
+
+// Issues API keys:
+//
+//	fueleconomy keys create -name <name> -scopes ingest,admin [-plan <plan>]
+//
+// The key is printed once; only its hash is stored.
+func runKeys(args []string) error {
+	if len(args) < 1 || args[0] != "create" {
+		return errors.New(keysUsage)
+	}
+	flags := flag.NewFlagSet("keys create", flag.ExitOnError)
+	name := flags.String("name", "", "Who or what the key is for")
+	scopes := flags.String("scopes", "read", "Comma separated scopes: read, ingest or admin")
+	plan := flags.String("plan", models.PlanDefault, "Rate limit plan")
+	flags.Parse(args[1:])
+	if *name == "" {
+		return errors.New(keysUsage)
+	}
+
+	key, apiKey, err := auth.CreateKey(*name, strings.Split(*scopes, ","), *plan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created key %d (%s) with scopes %s on plan %s:\n%s\n",
+		apiKey.ID, apiKey.Name, apiKey.ScopesText, apiKey.Plan, key)
+	return nil
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var levelNames []string = []string{"debug", "info", "warn", "error"}
+
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	for _, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			err := level.UnmarshalText([]byte(levelName))
+			return level, err
+		}
+	}
+	return slog.LevelInfo, errors.New(fmt.Sprintf("Unknown log level %s, expected one of %s",
+		name, strings.Join(levelNames, ", ")))
+}
+
+// A leveled logger writing one line per entry, as JSON objects or as text
+// with key=value fields. format is "json" or "text".
+func New(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceAttr}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown log format %s, expected json or text", format))
+}
+
+// Times are UTC and levels lower case, as they were before slog
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		a.Value = slog.TimeValue(a.Value.Time().UTC())
+	case slog.LevelKey:
+		a.Value = slog.StringValue(strings.ToLower(a.Value.String()))
+	}
+	return a
+}
+
+// Logs at error level, then exits
+func Fatal(l *slog.Logger, msg string, args ...interface{}) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+type contextKey struct{}
+
+// A context carrying a logger, e.g. one with a request's ID
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// The logger ctx carries, nil if none
+func FromContext(ctx context.Context) *slog.Logger {
+	l, _ := ctx.Value(contextKey{}).(*slog.Logger)
+	return l
+}
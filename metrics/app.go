@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fueleconomy_http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fueleconomy_http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	Jobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fueleconomy_jobs_total",
+		Help: "Worker jobs by target and outcome (succeeded, failed or awaiting_approval).",
+	}, []string{"target", "outcome"})
+	JobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fueleconomy_job_duration_seconds",
+		Help:    "Worker job duration by target and outcome.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"target", "outcome"})
+
+	RowsUpserted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fueleconomy_rows_upserted_total",
+		Help: "Rows upserted by table.",
+	}, []string{"table"})
+
+	DbOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fueleconomy_db_operation_duration_seconds",
+		Help:    "srm operation duration by operation, e.g. SelectMany, and outcome (ok or error).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "outcome"})
+)
+
+// Serves the default registry
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Records an srm operation, for DbMap.Observe
+func ObserveDbOperation(op srm.Operation) {
+	outcome := "ok"
+	if op.Err != nil {
+		outcome = "error"
+	}
+	DbOperationDuration.WithLabelValues(op.Name, outcome).Observe(op.Duration.Seconds())
+	if op.Err == nil && (op.Name == "UpsertOne" || op.Name == "UpsertMany") {
+		RowsUpserted.WithLabelValues(op.Table).Add(float64(op.Rows))
+	}
+}
+
+var (
+	dbPoolMu sync.Mutex
+	dbPool   prometheus.Collector
+)
+
+// Reports a connection pool's stats as the go_sql_* metrics, in place of the
+// pool watched before if the database was opened again
+func WatchDbPool(db *sql.DB) {
+	dbPoolMu.Lock()
+	defer dbPoolMu.Unlock()
+	if dbPool != nil {
+		prometheus.Unregister(dbPool)
+	}
+	dbPool = collectors.NewDBStatsCollector(db, "fueleconomy")
+	prometheus.MustRegister(dbPool)
+}
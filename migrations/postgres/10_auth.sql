@@ -0,0 +1,34 @@
+-- +migrate Up
+CREATE TABLE api_keys (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    name                     text,
+    prefix                   text,
+    hash                     text unique,
+    scopes                   text,
+    created_at               timestamptz,
+    revoked                  boolean default false
+);
+
+CREATE TABLE audit_log (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    key_id                   integer references api_keys(id),
+    key_name                 text,
+    action                   text,
+    target                   text,
+    job_id                   integer default 0,
+    remote_addr              text,
+    created_at               timestamptz
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON api_keys TO api;
+GRANT USAGE, SELECT, UPDATE ON api_keys_id_seq TO api;
+GRANT SELECT, UPDATE, INSERT, DELETE ON audit_log TO api;
+GRANT USAGE, SELECT, UPDATE ON audit_log_id_seq TO api;
+
+CREATE INDEX audit_log_key_id_idx ON audit_log (key_id);
+
+-- +migrate Down
+DROP TABLE audit_log;
+DROP TABLE api_keys;
@@ -0,0 +1,27 @@
+-- +migrate Up
+ALTER TABLE api_keys ADD COLUMN plan text default 'free';
+
+CREATE TABLE usage (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    key_id                   integer,
+    day                      text,
+    count                    integer default 0,
+    unique (key_id, day)
+);
+
+CREATE TABLE rate_limit_buckets (
+    bucket                   text primary key,
+    tokens                   double precision,
+    allowed                  boolean,
+    updated_at               timestamptz
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON usage TO api;
+GRANT USAGE, SELECT, UPDATE ON usage_id_seq TO api;
+GRANT SELECT, UPDATE, INSERT, DELETE ON rate_limit_buckets TO api;
+
+-- +migrate Down
+DROP TABLE rate_limit_buckets;
+DROP TABLE usage;
+ALTER TABLE api_keys DROP COLUMN plan;
@@ -0,0 +1,14 @@
+-- +migrate Up
+CREATE TABLE dataset_versions (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    job_id                   integer references jobs(id) on delete cascade,
+    target                   text,
+    created_at               timestamptz
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON dataset_versions TO api;
+GRANT USAGE, SELECT, UPDATE ON dataset_versions_id_seq TO api;
+
+-- +migrate Down
+DROP TABLE dataset_versions;
@@ -0,0 +1,11 @@
+-- +migrate Up
+-- Vehicles never withdrawn have the zero time, as they're written with
+ALTER TABLE vehicles ADD COLUMN withdrawn_at timestamptz default '0001-01-01 00:00:00+00';
+-- The grace period of vehicles already withdrawn counts from when they were last seen, as it did
+UPDATE vehicles SET withdrawn_at = last_seen_in_feed WHERE status <> 'active';
+
+CREATE INDEX vehicles_withdrawn_idx ON vehicles (status, withdrawn_at);
+
+-- +migrate Down
+DROP INDEX vehicles_withdrawn_idx;
+ALTER TABLE vehicles DROP COLUMN withdrawn_at;
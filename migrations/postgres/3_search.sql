@@ -0,0 +1,28 @@
+-- +migrate Up
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE vehicles ADD COLUMN base_model varchar(255);
+ALTER TABLE vehicles ADD COLUMN search_document text;
+
+-- Vehicles already ingested are searchable by their columns until the next
+-- ingest writes their tokenised documents
+UPDATE vehicles SET search_document = lower(concat_ws(' ', make, model, eng_dscr, trans_dscr, transition, year));
+
+CREATE INDEX vehicles_search_document_tsv_idx ON vehicles USING gin (to_tsvector('simple', search_document));
+CREATE INDEX vehicles_search_document_trgm_idx ON vehicles USING gin (search_document gin_trgm_ops);
+
+CREATE TABLE search_terms (
+    id                       serial primary key,
+    term                     varchar(255) unique,
+    frequency                integer
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON search_terms TO api;
+GRANT USAGE, SELECT, UPDATE ON search_terms_id_seq TO api;
+
+-- +migrate Down
+DROP TABLE search_terms;
+DROP INDEX vehicles_search_document_trgm_idx;
+DROP INDEX vehicles_search_document_tsv_idx;
+ALTER TABLE vehicles DROP COLUMN search_document;
+ALTER TABLE vehicles DROP COLUMN base_model;
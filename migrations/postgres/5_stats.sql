@@ -0,0 +1,43 @@
+-- +migrate Up
+CREATE TABLE vehicle_stats (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    group_by                 varchar(255),
+    group_key                varchar(255),
+    metric                   varchar(255),
+    count                    integer,
+    min_value                float8,
+    max_value                float8,
+    mean_value               float8,
+    median_value             float8,
+    p10_value                float8,
+    p25_value                float8,
+    p75_value                float8,
+    p90_value                float8
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON vehicle_stats TO api;
+GRANT USAGE, SELECT, UPDATE ON vehicle_stats_id_seq TO api;
+
+CREATE INDEX vehicle_stats_group_idx ON vehicle_stats (group_by, group_key);
+
+CREATE TABLE vehicle_rankings (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    epa_id                   integer references vehicles(epa_id) on delete cascade on update cascade,
+    year                     integer,
+    size_class               varchar(255),
+    group_size               integer,
+    mpg_percentile           float8,
+    co2_percentile           float8,
+    fuel_cost_percentile     float8
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON vehicle_rankings TO api;
+GRANT USAGE, SELECT, UPDATE ON vehicle_rankings_id_seq TO api;
+
+CREATE INDEX vehicle_rankings_id_idx ON vehicle_rankings (epa_id);
+
+-- +migrate Down
+DROP TABLE vehicle_rankings;
+DROP TABLE vehicle_stats;
@@ -0,0 +1,51 @@
+-- +migrate Up
+CREATE TABLE jobs (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    target                   varchar(255),
+    source                   varchar(255),
+    status                   varchar(255),
+    queued_at                timestamptz,
+    started_at               timestamptz,
+    finished_at              timestamptz,
+    error                    text
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON jobs TO api;
+GRANT USAGE, SELECT, UPDATE ON jobs_id_seq TO api;
+
+CREATE TABLE quality_reports (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    job_id                   integer references jobs(id) on delete cascade,
+    dataset                  varchar(255),
+    checked                  integer,
+    violating                integer,
+    aborted                  boolean
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON quality_reports TO api;
+GRANT USAGE, SELECT, UPDATE ON quality_reports_id_seq TO api;
+
+CREATE INDEX quality_reports_job_idx ON quality_reports (job_id);
+
+CREATE TABLE quality_violations (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    job_id                   integer references jobs(id) on delete cascade,
+    dataset                  varchar(255),
+    rule                     varchar(255),
+    description              text,
+    count                    integer,
+    sample_epa_ids           text
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON quality_violations TO api;
+GRANT USAGE, SELECT, UPDATE ON quality_violations_id_seq TO api;
+
+CREATE INDEX quality_violations_job_idx ON quality_violations (job_id);
+
+-- +migrate Down
+DROP TABLE quality_violations;
+DROP TABLE quality_reports;
+DROP TABLE jobs;
@@ -0,0 +1,24 @@
+-- +migrate Up
+ALTER TABLE jobs ADD COLUMN dry_run boolean default false;
+ALTER TABLE jobs ADD COLUMN approves_job_id integer default 0;
+
+CREATE TABLE ingest_changes (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    job_id                   integer references jobs(id) on delete cascade,
+    dataset                  varchar(255),
+    epa_id                   integer,
+    change_type              varchar(255),
+    fields                   text,
+    record                   text
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON ingest_changes TO api;
+GRANT USAGE, SELECT, UPDATE ON ingest_changes_id_seq TO api;
+
+CREATE INDEX ingest_changes_job_idx ON ingest_changes (job_id, dataset, change_type);
+
+-- +migrate Down
+DROP TABLE ingest_changes;
+ALTER TABLE jobs DROP COLUMN approves_job_id;
+ALTER TABLE jobs DROP COLUMN dry_run;
@@ -0,0 +1,10 @@
+-- +migrate Up
+ALTER TABLE vehicles ADD COLUMN status varchar(255) default 'active';
+ALTER TABLE vehicles ADD COLUMN last_seen_in_feed timestamptz default now();
+
+CREATE INDEX vehicles_status_idx ON vehicles (status, last_seen_in_feed);
+
+-- +migrate Down
+DROP INDEX vehicles_status_idx;
+ALTER TABLE vehicles DROP COLUMN last_seen_in_feed;
+ALTER TABLE vehicles DROP COLUMN status;
@@ -0,0 +1,16 @@
+-- +migrate Up
+CREATE TABLE emissions_info_orphans (
+    id                       serial primary key,
+    updated                  timestamptz default now(),
+    job_id                   integer references jobs(id) on delete cascade,
+    epa_id                   integer,
+    record                   text
+);
+
+GRANT SELECT, UPDATE, INSERT, DELETE ON emissions_info_orphans TO api;
+GRANT USAGE, SELECT, UPDATE ON emissions_info_orphans_id_seq TO api;
+
+CREATE INDEX emissions_info_orphans_id_idx ON emissions_info_orphans (epa_id);
+
+-- +migrate Down
+DROP TABLE emissions_info_orphans;
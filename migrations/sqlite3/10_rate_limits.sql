@@ -0,0 +1,14 @@
+-- +migrate Up
+ALTER TABLE api_keys ADD COLUMN plan text default 'free';
+
+CREATE TABLE usage (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    key_id                   integer,
+    day                      text,
+    count                    integer default 0,
+    unique (key_id, day)
+);
+
+-- +migrate Down
+DROP TABLE usage;
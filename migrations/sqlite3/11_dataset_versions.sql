@@ -0,0 +1,11 @@
+-- +migrate Up
+CREATE TABLE dataset_versions (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    job_id                   integer references jobs(id) on delete cascade,
+    target                   text,
+    created_at               timestamp
+);
+
+-- +migrate Down
+DROP TABLE dataset_versions;
@@ -0,0 +1,43 @@
+-- +migrate Up
+ALTER TABLE vehicles ADD COLUMN base_model varchar(255);
+ALTER TABLE vehicles ADD COLUMN search_document text;
+
+-- Vehicles already ingested are searchable by their columns until the next
+-- ingest writes their tokenised documents
+UPDATE vehicles SET search_document = lower(trim(ifnull(make, '') || ' ' || ifnull(model, '') || ' ' ||
+    ifnull(eng_dscr, '') || ' ' || ifnull(trans_dscr, '') || ' ' || ifnull(transition, '') || ' ' || ifnull(year, '')));
+
+CREATE VIRTUAL TABLE vehicles_search_document_fts USING fts5(search_document, content='vehicles', content_rowid='id');
+INSERT INTO vehicles_search_document_fts(vehicles_search_document_fts) VALUES ('rebuild');
+
+-- +migrate StatementBegin
+CREATE TRIGGER vehicles_search_document_fts_insert AFTER INSERT ON vehicles BEGIN
+    INSERT INTO vehicles_search_document_fts(rowid, search_document) VALUES (new.id, new.search_document);
+END;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE TRIGGER vehicles_search_document_fts_delete AFTER DELETE ON vehicles BEGIN
+    INSERT INTO vehicles_search_document_fts(vehicles_search_document_fts, rowid, search_document) VALUES ('delete', old.id, old.search_document);
+END;
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+CREATE TRIGGER vehicles_search_document_fts_update AFTER UPDATE ON vehicles BEGIN
+    INSERT INTO vehicles_search_document_fts(vehicles_search_document_fts, rowid, search_document) VALUES ('delete', old.id, old.search_document);
+    INSERT INTO vehicles_search_document_fts(rowid, search_document) VALUES (new.id, new.search_document);
+END;
+-- +migrate StatementEnd
+
+CREATE TABLE search_terms (
+    id                       integer primary key autoincrement,
+    term                     varchar(255) unique,
+    frequency                integer
+);
+
+-- +migrate Down
+DROP TABLE search_terms;
+DROP TRIGGER vehicles_search_document_fts_update;
+DROP TRIGGER vehicles_search_document_fts_delete;
+DROP TRIGGER vehicles_search_document_fts_insert;
+DROP TABLE vehicles_search_document_fts;
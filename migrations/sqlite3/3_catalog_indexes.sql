@@ -0,0 +1,9 @@
+-- +migrate Up
+CREATE INDEX vehicles_year_make_model_idx ON vehicles (year, make, model);
+CREATE INDEX vehicles_lower_make_idx ON vehicles (lower(make));
+CREATE INDEX vehicles_lower_model_idx ON vehicles (lower(model));
+
+-- +migrate Down
+DROP INDEX vehicles_year_make_model_idx;
+DROP INDEX vehicles_lower_make_idx;
+DROP INDEX vehicles_lower_model_idx;
@@ -0,0 +1,37 @@
+-- +migrate Up
+CREATE TABLE vehicle_stats (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    group_by                 varchar(255),
+    group_key                varchar(255),
+    metric                   varchar(255),
+    count                    integer,
+    min_value                real,
+    max_value                real,
+    mean_value               real,
+    median_value             real,
+    p10_value                real,
+    p25_value                real,
+    p75_value                real,
+    p90_value                real
+);
+
+CREATE INDEX vehicle_stats_group_idx ON vehicle_stats (group_by, group_key);
+
+CREATE TABLE vehicle_rankings (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    epa_id                   integer references vehicles(epa_id) on delete cascade on update cascade,
+    year                     integer,
+    size_class               varchar(255),
+    group_size               integer,
+    mpg_percentile           real,
+    co2_percentile           real,
+    fuel_cost_percentile     real
+);
+
+CREATE INDEX vehicle_rankings_id_idx ON vehicle_rankings (epa_id);
+
+-- +migrate Down
+DROP TABLE vehicle_rankings;
+DROP TABLE vehicle_stats;
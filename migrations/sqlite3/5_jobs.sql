@@ -0,0 +1,42 @@
+-- +migrate Up
+CREATE TABLE jobs (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    target                   varchar(255),
+    source                   varchar(255),
+    status                   varchar(255),
+    queued_at                timestamp,
+    started_at               timestamp,
+    finished_at              timestamp,
+    error                    text
+);
+
+CREATE TABLE quality_reports (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    job_id                   integer references jobs(id) on delete cascade,
+    dataset                  varchar(255),
+    checked                  integer,
+    violating                integer,
+    aborted                  boolean
+);
+
+CREATE INDEX quality_reports_job_idx ON quality_reports (job_id);
+
+CREATE TABLE quality_violations (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    job_id                   integer references jobs(id) on delete cascade,
+    dataset                  varchar(255),
+    rule                     varchar(255),
+    description              text,
+    count                    integer,
+    sample_epa_ids           text
+);
+
+CREATE INDEX quality_violations_job_idx ON quality_violations (job_id);
+
+-- +migrate Down
+DROP TABLE quality_violations;
+DROP TABLE quality_reports;
+DROP TABLE jobs;
@@ -0,0 +1,19 @@
+-- +migrate Up
+ALTER TABLE jobs ADD COLUMN dry_run boolean default false;
+ALTER TABLE jobs ADD COLUMN approves_job_id integer default 0;
+
+CREATE TABLE ingest_changes (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    job_id                   integer references jobs(id) on delete cascade,
+    dataset                  varchar(255),
+    epa_id                   integer,
+    change_type              varchar(255),
+    fields                   text,
+    record                   text
+);
+
+CREATE INDEX ingest_changes_job_idx ON ingest_changes (job_id, dataset, change_type);
+
+-- +migrate Down
+DROP TABLE ingest_changes;
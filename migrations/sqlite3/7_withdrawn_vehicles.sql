@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE vehicles ADD COLUMN status varchar(255) default 'active';
+ALTER TABLE vehicles ADD COLUMN last_seen_in_feed timestamp;
+UPDATE vehicles SET last_seen_in_feed = current_timestamp;
+
+CREATE INDEX vehicles_status_idx ON vehicles (status, last_seen_in_feed);
+
+-- +migrate Down
+DROP INDEX vehicles_status_idx;
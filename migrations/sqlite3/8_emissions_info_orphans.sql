@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE emissions_info_orphans (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    job_id                   integer references jobs(id) on delete cascade,
+    epa_id                   integer,
+    record                   text
+);
+
+CREATE INDEX emissions_info_orphans_id_idx ON emissions_info_orphans (epa_id);
+
+-- +migrate Down
+DROP TABLE emissions_info_orphans;
@@ -0,0 +1,29 @@
+-- +migrate Up
+CREATE TABLE api_keys (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    name                     text,
+    prefix                   text,
+    hash                     text unique,
+    scopes                   text,
+    created_at               timestamp,
+    revoked                  boolean default false
+);
+
+CREATE TABLE audit_log (
+    id                       integer primary key autoincrement,
+    updated                  timestamp default current_timestamp,
+    key_id                   integer references api_keys(id),
+    key_name                 text,
+    action                   text,
+    target                   text,
+    job_id                   integer default 0,
+    remote_addr              text,
+    created_at               timestamp
+);
+
+CREATE INDEX audit_log_key_id_idx ON audit_log (key_id);
+
+-- +migrate Down
+DROP TABLE audit_log;
+DROP TABLE api_keys;
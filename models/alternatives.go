@@ -0,0 +1,165 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// A comparable vehicle with what switching to it saves under a driving profile
+type Alternative struct {
+	AnnualCo2SavedKg    float64  `json:"annualCo2SavedKg"`    // Tailpipe CO2 saved per year in kilograms, negative if more is emitted
+	AnnualFuelCostSaved int      `json:"annualFuelCostSaved"` // Fuel cost saved per year ($), negative if more is spent
+	Reasons             []string `json:"reasons"`             // Why the vehicle was matched
+	Vehicle             Vehicle  `json:"vehicle"`
+}
+
+var (
+	// Passenger and luggage volumes within this fraction of each other are comparable
+	VolumeTolerance float64 = 0.15
+
+	// EPA size classes from smallest to largest within each family. Classes on
+	// the same or adjacent rungs are comparable.
+	SizeClassLadders [][][]string = [][][]string{
+		{
+			{"Minicompact Cars"},
+			{"Subcompact Cars"},
+			{"Compact Cars"},
+			{"Midsize Cars"},
+			{"Large Cars"},
+		},
+		{
+			{"Small Station Wagons"},
+			{"Midsize Station Wagons"},
+			{"Midsize-Large Station Wagons", "Large Station Wagons"},
+		},
+		{
+			{"Small Sport Utility Vehicle 2WD", "Small Sport Utility Vehicle 4WD"},
+			{"Standard Sport Utility Vehicle 2WD", "Standard Sport Utility Vehicle 4WD",
+				"Sport Utility Vehicle - 2WD", "Sport Utility Vehicle - 4WD"},
+		},
+		{
+			{"Small Pickup Trucks", "Small Pickup Trucks 2WD", "Small Pickup Trucks 4WD"},
+			{"Standard Pickup Trucks", "Standard Pickup Trucks 2WD", "Standard Pickup Trucks 4WD",
+				"Standard Pickup Trucks/2wd"},
+		},
+		{
+			{"Minivan - 2WD", "Minivan - 4WD"},
+			{"Vans", "Vans, Cargo Type", "Vans, Passenger Type", "Vans Passenger"},
+		},
+	}
+)
+
+// Size classes on the same or an adjacent rung of the size class's ladder,
+// or just the size class itself if it isn't on one
+func ComparableSizeClasses(sizeClass string) []string {
+	for _, ladder := range SizeClassLadders {
+		for i, rung := range ladder {
+			if !containsString(rung, sizeClass) {
+				continue
+			}
+			classes := make([]string, 0)
+			for j := maxInt(i-1, 0); j <= minInt(i+1, len(ladder)-1); j++ {
+				classes = append(classes, ladder[j]...)
+			}
+			return classes
+		}
+	}
+	return []string{sizeClass}
+}
+
+// Two wheel drive or all/four wheel drive
+func DriveCategory(driveAxleType string) string {
+	drive := strings.ToLower(driveAxleType)
+	if strings.Contains(drive, "4-wheel") || strings.Contains(drive, "all-wheel") {
+		return "4WD/AWD"
+	}
+	return "2WD"
+}
+
+// Checks a candidate is comparable to the target vehicle and explains why.
+// Both vehicles must have fuel data calculated under the driving profile.
+func NewAlternative(target *Vehicle, candidate *Vehicle, d DrivingProfile) (Alternative, bool) {
+	reasons := make([]string, 0)
+
+	if candidate.SizeClass == target.SizeClass {
+		reasons = append(reasons, fmt.Sprintf("Same size class (%s)", candidate.SizeClass))
+	} else if containsString(ComparableSizeClasses(target.SizeClass), candidate.SizeClass) {
+		reasons = append(reasons, fmt.Sprintf("Adjacent size class (%s)", candidate.SizeClass))
+	} else {
+		return Alternative{}, false
+	}
+
+	if candidate.Year == target.Year {
+		reasons = append(reasons, fmt.Sprintf("Same model year (%d)", candidate.Year))
+	} else {
+		reasons = append(reasons, fmt.Sprintf("Adjacent model year (%d)", candidate.Year))
+	}
+
+	if DriveCategory(candidate.DriveAxleType) != DriveCategory(target.DriveAxleType) {
+		return Alternative{}, false
+	}
+	reasons = append(reasons, fmt.Sprintf("Comparable drive (%s)", candidate.DriveAxleType))
+
+	volumes := []struct {
+		name             string
+		target, matching int
+	}{
+		{"passenger", passengerVolume(target), passengerVolume(candidate)},
+		{"luggage", luggageVolume(target), luggageVolume(candidate)},
+	}
+	for _, volume := range volumes {
+		if volume.target == 0 || volume.matching == 0 {
+			continue
+		}
+		diff := math.Abs(float64(volume.matching-volume.target)) / float64(volume.target)
+		if diff > VolumeTolerance {
+			return Alternative{}, false
+		}
+		reasons = append(reasons, fmt.Sprintf("Comparable %s volume (%d vs %d cubic feet)",
+			volume.name, volume.matching, volume.target))
+	}
+
+	alt := Alternative{Vehicle: *candidate}
+	targetFuel, candidateFuel := target.Fuels[0], candidate.Fuels[0]
+	if targetFuel.FuelCost > 0 && candidateFuel.FuelCost > 0 {
+		alt.AnnualFuelCostSaved = targetFuel.FuelCost - candidateFuel.FuelCost
+		if alt.AnnualFuelCostSaved > 0 {
+			reasons = append(reasons, fmt.Sprintf("Saves $%d per year in fuel", alt.AnnualFuelCostSaved))
+		}
+	}
+	alt.AnnualCo2SavedKg = toFixed((targetFuel.Co2Tailpipe-candidateFuel.Co2Tailpipe)*
+		float64(d.MilesPerYear)/1000.0, 1)
+	if alt.AnnualCo2SavedKg > 0.0 {
+		reasons = append(reasons, fmt.Sprintf("Emits %.0f kg less tailpipe CO2 per year", alt.AnnualCo2SavedKg))
+	}
+
+	alt.Reasons = reasons
+	return alt, true
+}
+
+// Largest passenger volume of the 2 door, 4 door and hatchback configurations
+func passengerVolume(v *Vehicle) int {
+	return maxInt(maxInt(v.PassengerVolume2Door, v.PassengerVolume4Door), v.PassengerVolumeHatch)
+}
+
+// Largest luggage volume of the 2 door, 4 door and hatchback configurations
+func luggageVolume(v *Vehicle) int {
+	return maxInt(maxInt(v.LuggageVolume2Door, v.LuggageVolume4Door), v.LuggageVolumeHatch)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
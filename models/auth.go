@@ -0,0 +1,124 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRead   string = "read"   // the public API
+	ScopeIngest string = "ingest" // ingests and their jobs
+	ScopeAdmin  string = "admin"  // everything, including key management
+)
+
+var Scopes []string = []string{ScopeRead, ScopeIngest, ScopeAdmin}
+
+// Keys are shown once when issued, then only their prefix
+const APIKeyPrefixLength int = 11
+
+const (
+	PlanAnonymous string = "anonymous" // requests without a key, limited per address
+	PlanDefault   string = "free"      // keys issued without a plan
+)
+
+// A token bucket holding up to Burst requests, refilled at RequestsPerMinute
+type RateLimitPlan struct {
+	RequestsPerMinute int `json:"requestsPerMinute"`
+	Burst             int `json:"burst"`
+}
+
+// Requests made with a key on a UTC day. Anonymous requests are key 0.
+type Usage struct {
+	ID      int       `db:"id, primaryKey" json:"-"`   // Our ID
+	Updated time.Time `db:"updated, autoSet" json:"-"` // Our update timestamp
+	KeyID   int       `db:"key_id" json:"keyId"`       // Key the requests were made with
+	Day     string    `db:"day" json:"day"`            // YYYY-MM-DD
+	Count   int       `db:"count" json:"count"`        // Number of requests
+}
+
+// An API key. Only its SHA-256 hash is stored.
+type APIKey struct {
+	ID         int       `db:"id, primaryKey" json:"id"`        // Our ID
+	Updated    time.Time `db:"updated, autoSet" json:"updated"` // Our update timestamp
+	Name       string    `db:"name" json:"name"`                // Who or what the key is for
+	Prefix     string    `db:"prefix" json:"prefix"`            // Start of the key, to tell keys apart
+	Hash       string    `db:"hash" json:"-"`                   // Hex SHA-256 of the key
+	ScopesText string    `db:"scopes" json:"-"`                 // Comma separated Scopes
+	Scopes     []string  `db:"-" json:"scopes"`                 // read, ingest or admin
+	Plan       string    `db:"plan" json:"plan"`                // Rate limit plan
+	CreatedAt  time.Time `db:"created_at" json:"createdAt"`     // When the key was issued
+	Revoked    bool      `db:"revoked" json:"revoked"`          // Revoked keys are refused
+}
+
+// Who did what, and when
+type AuditEntry struct {
+	ID         int       `db:"id, primaryKey" json:"id"`      // Our ID
+	Updated    time.Time `db:"updated, autoSet" json:"-"`     // Our update timestamp
+	KeyID      int       `db:"key_id" json:"keyId"`           // Key the request was made with
+	KeyName    string    `db:"key_name" json:"keyName"`       // The key's name at the time
+	Action     string    `db:"action" json:"action"`          // e.g. ingest or approve
+	Target     string    `db:"target" json:"target"`          // What was acted on, e.g. vehicles
+	JobID      int       `db:"job_id" json:"jobId,omitempty"` // Job the action queued
+	RemoteAddr string    `db:"remote_addr" json:"remoteAddr"` // Where the request came from
+	CreatedAt  time.Time `db:"created_at" json:"createdAt"`   // When the action was taken
+}
+
+// Generates a key, returning it along with its record to store
+func NewAPIKey(name string, scopes []string, plan string) (string, *APIKey, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", nil, err
+	}
+	key := "fe_" + hex.EncodeToString(b)
+	apiKey := &APIKey{
+		Name:      name,
+		Prefix:    key[:APIKeyPrefixLength],
+		Hash:      HashAPIKey(key),
+		Scopes:    scopes,
+		Plan:      plan,
+		CreatedAt: time.Now().UTC(),
+	}
+	apiKey.FillScopesText()
+	return key, apiKey, nil
+}
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Admin keys have every scope, and ingest keys can read
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin || (s == ScopeIngest && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) FillScopes() {
+	k.Scopes = make([]string, 0)
+	for _, scope := range strings.Split(k.ScopesText, ",") {
+		if scope != "" {
+			k.Scopes = append(k.Scopes, scope)
+		}
+	}
+}
+
+func (k *APIKey) FillScopesText() {
+	k.ScopesText = strings.Join(k.Scopes, ",")
+}
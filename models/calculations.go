@@ -6,6 +6,10 @@ var (
 	CityShareDefault    int = 55
 	HighwayShareDefault int = 45
 	MilesPerYearDefault int = 15000
+
+	// Bounds on a sane annual mileage
+	MilesPerYearMin int = 1
+	MilesPerYearMax int = 200000
 )
 
 type DrivingProfile struct {
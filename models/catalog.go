@@ -0,0 +1,20 @@
+package models
+
+// A distinct variant of a year, make and model
+type VehicleOption struct {
+	EpaID           int     `db:"epa_id" json:"epaID"`                               // vehicle record id
+	Cylinders       int     `db:"cylinders" json:"cylinders,omitempty"`              // engine cylinders
+	DriveAxleType   string  `db:"drive_axle_type" json:"driveAxleType,omitempty"`    // drive axle type
+	EngDisplacement float64 `db:"eng_displacement" json:"engDisplacement,omitempty"` // engine displacement in liters
+	EngDscr         string  `db:"eng_dscr" json:"engDscr,omitempty"`                 // engine descriptor
+	FuelType        string  `db:"fuel_type" json:"fuelType,omitempty"`               // fuel type with fuelType1 and fuelType2 (if applicable)
+	TransDscr       string  `db:"trans_dscr" json:"transDscr,omitempty"`             // transmission descriptor
+	Transition      string  `db:"transition" json:"transition,omitempty"`            // transmission
+}
+
+// A make, or make and model, matching an autocomplete prefix
+type Suggestion struct {
+	Make  string `db:"make" json:"make"`
+	Model string `db:"model" json:"model,omitempty"`
+	Text  string `db:"-" json:"text"`
+}
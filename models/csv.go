@@ -0,0 +1,173 @@
+package models
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+var (
+	// Header of EPA's vehicles.csv, in order. Columns are matched to
+	// RawVehicle by xml tag; those without a field (rangeCity, rangeHwy) are
+	// checked but ignored.
+	VehiclesCSVColumns []string = strings.Split("barrels08,barrelsA08,charge120,charge240,"+
+		"city08,city08U,cityA08,cityA08U,cityCD,cityE,cityUF,co2,co2A,co2TailpipeAGpm,"+
+		"co2TailpipeGpm,comb08,comb08U,combA08,combA08U,combE,combinedCD,combinedUF,"+
+		"cylinders,displ,drive,engId,eng_dscr,feScore,fuelCost08,fuelCostA08,fuelType,"+
+		"fuelType1,ghgScore,ghgScoreA,highway08,highway08U,highwayA08,highwayA08U,"+
+		"highwayCD,highwayE,highwayUF,hlv,hpv,id,lv2,lv4,make,model,mpgData,phevBlended,"+
+		"pv2,pv4,range,rangeCity,rangeCityA,rangeHwy,rangeHwyA,trany,UCity,UCityA,"+
+		"UHighway,UHighwayA,VClass,year,youSaveSpend,guzzler,trans_dscr,tCharger,"+
+		"sCharger,atvType,fuelType2,rangeA,evMotor,mfrCode,c240Dscr,charge240b,"+
+		"c240bDscr,createdOn,modifiedOn,startStop,phevCity,phevHwy,phevComb,baseModel", ",")
+
+	// Header of EPA's emissions.csv, in order
+	EmissionsInfoCSVColumns []string = strings.Split(
+		"efid,id,salesArea,score,scoreAlt,smartwayScore,standard,stdText", ",")
+)
+
+// EPA's header differs from what we expect: columns were added, renamed,
+// removed or moved. Ingesting is refused rather than guessing.
+type CSVHeaderError struct {
+	File       string
+	Missing    []string // expected but not found, e.g. renamed or removed
+	Unexpected []string // found but not expected, e.g. added or renamed
+	Reordered  bool
+}
+
+func (e *CSVHeaderError) Error() string {
+	problems := make([]string, 0)
+	if len(e.Missing) > 0 {
+		problems = append(problems, fmt.Sprintf("missing columns %v", e.Missing))
+	}
+	if len(e.Unexpected) > 0 {
+		problems = append(problems, fmt.Sprintf("unexpected columns %v", e.Unexpected))
+	}
+	if e.Reordered {
+		problems = append(problems, "columns reordered")
+	}
+	return fmt.Sprintf("%s header drift: %s", e.File, strings.Join(problems, ", "))
+}
+
+// Decodes vehicles from EPA's XML or CSV format
+func DecodeRawVehicles(data []byte) ([]RawVehicle, error) {
+	if IsXML(data) {
+		var rvo RawVehiclesOuter
+		err := xml.Unmarshal(data, &rvo)
+		return rvo.RawVehicles, err
+	}
+	rvs := make([]RawVehicle, 0)
+	err := decodeCSV(bytes.NewReader(data), "vehicles.csv", VehiclesCSVColumns, &rvs)
+	return rvs, err
+}
+
+// Decodes emissions info from EPA's XML or CSV format
+func DecodeRawEmissionsInfoes(data []byte) ([]RawEmissionsInfo, error) {
+	if IsXML(data) {
+		var reo RawEmissionsInfoOuter
+		err := xml.Unmarshal(data, &reo)
+		return reo.RawEmissionsInfoes, err
+	}
+	reis := make([]RawEmissionsInfo, 0)
+	err := decodeCSV(bytes.NewReader(data), "emissions.csv", EmissionsInfoCSVColumns, &reis)
+	return reis, err
+}
+
+func IsXML(data []byte) bool {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("<"))
+}
+
+var utf8BOM []byte = []byte("\xef\xbb\xbf")
+
+// Decodes rows into a slice of raw structs, setting each string field from
+// the column named by its xml tag. The header must match expected exactly.
+func decodeCSV(r io.Reader, file string, expected []string, slicePtr interface{}) error {
+	sliceVal := reflect.ValueOf(slicePtr).Elem()
+	rawType := sliceVal.Type().Elem()
+
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return errors.New(fmt.Sprintf("%s is empty", file))
+	} else if err != nil {
+		return err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], string(utf8BOM))
+	}
+	if err = checkCSVHeader(file, header, expected); err != nil {
+		return err
+	}
+	reader.FieldsPerRecord = len(header)
+	reader.ReuseRecord = true
+
+	// Column index for each field, -1 for fields without a column
+	fieldCols := make([]int, rawType.NumField())
+	for i := range fieldCols {
+		fieldCols[i] = -1
+		tag := rawType.Field(i).Tag.Get("xml")
+		for j, col := range header {
+			if col == tag {
+				fieldCols[i] = j
+				break
+			}
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		raw := reflect.New(rawType).Elem()
+		for i, col := range fieldCols {
+			if col >= 0 {
+				raw.Field(i).SetString(record[col])
+			}
+		}
+		sliceVal.Set(reflect.Append(sliceVal, raw))
+	}
+}
+
+func checkCSVHeader(file string, header []string, expected []string) error {
+	e := &CSVHeaderError{File: file}
+	seen := make(map[string]int, len(header))
+	for _, col := range header {
+		seen[col]++
+	}
+	wanted := make(map[string]bool, len(expected))
+	for _, col := range expected {
+		wanted[col] = true
+		if seen[col] == 0 {
+			e.Missing = append(e.Missing, col)
+		}
+	}
+	reported := make(map[string]bool)
+	for _, col := range header {
+		// Duplicated columns are unexpected too
+		if (!wanted[col] || seen[col] > 1) && !reported[col] {
+			e.Unexpected = append(e.Unexpected, col)
+			reported[col] = true
+		}
+	}
+	if len(e.Missing) == 0 && len(e.Unexpected) == 0 {
+		for i := range header {
+			if header[i] != expected[i] {
+				e.Reordered = true
+				break
+			}
+		}
+	}
+	if len(e.Missing) > 0 || len(e.Unexpected) > 0 || e.Reordered {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ChangeAdded   string = "added"
+	ChangeRemoved string = "removed"
+	ChangeChanged string = "changed"
+)
+
+// How one vehicle, or one vehicle's emissions info, would change if a dry run
+// ingest were approved
+type IngestChange struct {
+	ID         int           `db:"id, primaryKey" json:"-"`   // Our ID
+	Updated    time.Time     `db:"updated, autoSet" json:"-"` // Our update timestamp
+	JobID      int           `db:"job_id" json:"-"`           // Dry run job
+	Dataset    string        `db:"dataset" json:"dataset"`    // vehicles or emissions
+	EpaID      int           `db:"epa_id" json:"epaId"`       // vehicle record id
+	ChangeType string        `db:"change_type" json:"change"` // added, removed or changed
+	FieldsText string        `db:"fields" json:"-"`           // JSON encoded Fields
+	Fields     []FieldChange `db:"-" json:"fields,omitempty"` // Changed fields, for changed records
+	Record     string        `db:"record" json:"-"`           // JSON encoded raw record(s) applied on approval
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Columns not compared: ours rather than the EPA's, or derived
+var diffSkippedColumns map[string]bool = map[string]bool{
+	"id": true, "updated": true, "epa_id": true, "search_document": true, "last_seen_in_feed": true,
+	"withdrawn_at": true,
+}
+
+// Changed columns between a stored vehicle and one converted from a release
+func DiffVehicles(old *Vehicle, new *Vehicle) []FieldChange {
+	return diffStructs("", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem())
+}
+
+// Changed columns between a vehicle's stored emissions info and a release's.
+// Rows are matched by sales area and engine family, and fields named
+// <sales area>/<engine family>.<column>. Unmatched rows are reported whole
+// under "rows".
+func DiffEmissionsInfoes(old []EmissionsInfo, new []EmissionsInfo) []FieldChange {
+	changes := make([]FieldChange, 0)
+	key := func(ei *EmissionsInfo) string {
+		return fmt.Sprintf("%d/%s", ei.SalesArea, ei.EngineFamilyID)
+	}
+	oldByKey := make(map[string]*EmissionsInfo)
+	for i := range old {
+		oldByKey[key(&old[i])] = &old[i]
+	}
+	newByKey := make(map[string]*EmissionsInfo)
+	for i := range new {
+		newByKey[key(&new[i])] = &new[i]
+	}
+
+	keys := make([]string, 0)
+	for k := range oldByKey {
+		keys = append(keys, k)
+	}
+	for k := range newByKey {
+		if _, ok := oldByKey[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		o, inOld := oldByKey[k]
+		n, inNew := newByKey[k]
+		switch {
+		case inOld && inNew:
+			changes = append(changes, diffStructs(k+".", reflect.ValueOf(o).Elem(), reflect.ValueOf(n).Elem())...)
+		case inOld:
+			changes = append(changes, FieldChange{Field: k, Old: o, New: nil})
+		default:
+			changes = append(changes, FieldChange{Field: k, Old: nil, New: n})
+		}
+	}
+	return changes
+}
+
+func diffStructs(prefix string, old reflect.Value, new reflect.Value) []FieldChange {
+	changes := make([]FieldChange, 0)
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		col := strings.Split(t.Field(i).Tag.Get("db"), ", ")[0]
+		if col == "" || col == "-" || diffSkippedColumns[col] {
+			continue
+		}
+		o, n := old.Field(i).Interface(), new.Field(i).Interface()
+		if ot, ok := o.(time.Time); ok {
+			if ot.Equal(n.(time.Time)) {
+				continue
+			}
+		} else if reflect.DeepEqual(o, n) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: prefix + col, Old: o, New: n})
+	}
+	return changes
+}
+
+func (c *IngestChange) FillFields() error {
+	c.Fields = make([]FieldChange, 0)
+	if c.FieldsText == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(c.FieldsText), &c.Fields)
+}
+
+func (c *IngestChange) FillFieldsText() error {
+	if len(c.Fields) == 0 {
+		c.FieldsText = ""
+		return nil
+	}
+	b, err := json.Marshal(c.Fields)
+	c.FieldsText = string(b)
+	return err
+}
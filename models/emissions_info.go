@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"reflect"
 	"time"
 )
@@ -18,6 +19,16 @@ type EmissionsInfo struct {
 	SmartwayScore   int       `db:"smartway_score" json:"smartwayScore,omitempty"`      // SmartWay Code
 }
 
+// Emissions info for a vehicle we don't have, kept as EPA published it until
+// a vehicles ingest brings the vehicle in
+type EmissionsInfoOrphan struct {
+	ID      int       `db:"id, primaryKey" json:"-"`         // Our ID
+	Updated time.Time `db:"updated, autoSet" json:"updated"` // Our update timestamp
+	JobID   int       `db:"job_id" json:"jobId"`             // Ingest job the record was orphaned in
+	EpaID   int       `db:"epa_id" json:"epaId"`             // Missing vehicle record ID
+	Record  string    `db:"record" json:"-"`                 // JSON encoded RawEmissionsInfo
+}
+
 type RawEmissionsInfoOuter struct {
 	RawEmissionsInfoes []RawEmissionsInfo `xml:"emissionsInfo"`
 }
@@ -47,3 +58,21 @@ func NewEmissionsInfoFromRaw(r *RawEmissionsInfo) (*EmissionsInfo, error) {
 	}
 	return &e, nil
 }
+
+func NewEmissionsInfoOrphan(jobID int, epaID int, raw *RawEmissionsInfo) (*EmissionsInfoOrphan, error) {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &EmissionsInfoOrphan{JobID: jobID, EpaID: epaID, Record: string(b)}, nil
+}
+
+// The emissions info the orphan holds
+func (o *EmissionsInfoOrphan) EmissionsInfo() (*EmissionsInfo, error) {
+	var raw RawEmissionsInfo
+	err := json.Unmarshal([]byte(o.Record), &raw)
+	if err != nil {
+		return nil, err
+	}
+	return NewEmissionsInfoFromRaw(&raw)
+}
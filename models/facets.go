@@ -0,0 +1,7 @@
+package models
+
+// Number of vehicles sharing a value of a faceted field
+type FacetCount struct {
+	Value string `db:"group_key" json:"value"`
+	Count int    `db:"count" json:"count"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+)
+
+var (
+	// Vehicle JSON field names mapped to the vehicles column each is read
+	// from. Fields that aren't read from a column (fuels, emissionsInfo,
+	// rankings) map to "".
+	VehicleFieldColumns map[string]string = vehicleFieldColumns()
+
+	// Fuel JSON field names
+	FuelFieldNames map[string]bool = fuelFieldNames()
+
+	// Columns CalculateFuelData reads
+	FuelDataColumns []string = fuelDataColumns()
+)
+
+func vehicleFieldColumns() map[string]string {
+	out := make(map[string]string)
+	t := reflect.TypeOf(Vehicle{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		col := strings.Split(field.Tag.Get("db"), ", ")[0]
+		if col == "-" {
+			col = ""
+		}
+		out[name] = col
+	}
+	return out
+}
+
+func fuelFieldNames() map[string]bool {
+	out := make(map[string]bool)
+	t := reflect.TypeOf(Fuel{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "-" {
+			out[name] = true
+		}
+	}
+	return out
+}
+
+func fuelDataColumns() []string {
+	out := make([]string, 0)
+	t := reflect.TypeOf(Vehicle{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		for _, prefix := range []string{"F1", "F2", "Phev", "ECity", "EComb", "EHighway"} {
+			if strings.HasPrefix(field.Name, prefix) {
+				out = append(out, strings.Split(field.Tag.Get("db"), ", ")[0])
+				break
+			}
+		}
+	}
+	return out
+}
+
+func jsonName(field reflect.StructField) string {
+	return strings.TrimSpace(strings.Split(field.Tag.Get("json"), ",")[0])
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Vehicles have at most two fuels
+const MaxFuels int = 2
+
+var (
+	flatVehicleFields []int    = flatVehicleFieldIndexes()
+	flatFuelFields    []int    = flatFuelFieldIndexes()
+	flatColumns       []string = flatColumnNames()
+)
+
+// Column names of a flattened vehicle: its scalar JSON fields named by their
+// vehicles column, then each fuel's JSON fields prefixed fuel1_ and fuel2_,
+// e.g. fuel1_mpg_comb. Emissions info and rankings are left out.
+func FlatColumns() []string {
+	return flatColumns
+}
+
+// Values of a vehicle with fuel data calculated, in FlatColumns order. A
+// missing second fuel leaves its columns nil.
+func (v *Vehicle) FlatRecord() []interface{} {
+	record := make([]interface{}, 0, len(flatColumns))
+	vv := reflect.ValueOf(v).Elem()
+	for _, i := range flatVehicleFields {
+		record = append(record, vv.Field(i).Interface())
+	}
+	for n := 0; n < MaxFuels; n++ {
+		for _, i := range flatFuelFields {
+			if n < len(v.Fuels) {
+				record = append(record, reflect.ValueOf(v.Fuels[n]).Field(i).Interface())
+			} else {
+				record = append(record, nil)
+			}
+		}
+	}
+	return record
+}
+
+func flatVehicleFieldIndexes() []int {
+	out := make([]int, 0)
+	t := reflect.TypeOf(Vehicle{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		col := strings.Split(field.Tag.Get("db"), ", ")[0]
+		if jsonName(field) == "-" || col == "-" {
+			continue
+		}
+		out = append(out, i)
+	}
+	return out
+}
+
+func flatFuelFieldIndexes() []int {
+	out := make([]int, 0)
+	t := reflect.TypeOf(Fuel{})
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) != "-" {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func flatColumnNames() []string {
+	out := make([]string, 0)
+	vt := reflect.TypeOf(Vehicle{})
+	for _, i := range flatVehicleFields {
+		out = append(out, strings.Split(vt.Field(i).Tag.Get("db"), ", ")[0])
+	}
+	ft := reflect.TypeOf(Fuel{})
+	for n := 1; n <= MaxFuels; n++ {
+		for _, i := range flatFuelFields {
+			out = append(out, fmt.Sprintf("fuel%d_%s", n, snakeCase(jsonName(ft.Field(i)))))
+		}
+	}
+	return out
+}
+
+// mpgComb -> mpg_comb, phevCDCity -> phev_cd_city
+func snakeCase(name string) string {
+	runes := []rune(name)
+	out := make([]rune, 0, len(runes)+4)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			endOfAcronym := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || endOfAcronym {
+				out = append(out, '_')
+			}
+		}
+		out = append(out, unicode.ToLower(r))
+	}
+	return string(out)
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	JobQueued           string = "queued"
+	JobRunning          string = "running"
+	JobSucceeded        string = "succeeded"
+	JobFailed           string = "failed"
+	JobAwaitingApproval string = "awaiting_approval" // a dry run whose changes can be applied
+	JobApproved         string = "approved"          // a dry run whose changes were applied
+)
+
+// A run of a worker target, e.g. an ingest of vehicles
+type Job struct {
+	ID         int       `db:"id, primaryKey" json:"id"`                       // Our ID
+	Updated    time.Time `db:"updated, autoSet" json:"updated"`                // Our update timestamp
+	Target     string    `db:"target" json:"target"`                           // Worker target, e.g. vehicles
+	Source     string    `db:"source" json:"source,omitempty"`                 // Ingestion source if not the EPA
+	Status     string    `db:"status" json:"status"`                           // queued, running, succeeded, failed, awaiting_approval or approved
+	QueuedAt   time.Time `db:"queued_at" json:"queuedAt"`                      // When the job was requested
+	StartedAt  time.Time `db:"started_at" json:"startedAt"`                    // When a worker picked the job up
+	FinishedAt time.Time `db:"finished_at" json:"finishedAt"`                  // When the job succeeded or failed
+	Error      string    `db:"error" json:"error,omitempty"`                   // Why the job failed
+	DryRun     bool      `db:"dry_run" json:"dryRun"`                          // Changes are recorded for approval, not made
+	ApprovesID int       `db:"approves_job_id" json:"approvesJobId,omitempty"` // Dry run whose changes this job applies
+}
+
+// A version of the dataset, one per job that changed it. Responses derived
+// from the dataset are cached against the latest version.
+type DatasetVersion struct {
+	ID        int       `db:"id, primaryKey" json:"version"` // The version
+	Updated   time.Time `db:"updated, autoSet" json:"-"`     // Our update timestamp
+	JobID     int       `db:"job_id" json:"jobId"`           // Job that changed the dataset
+	Target    string    `db:"target" json:"target"`          // The job's target, e.g. vehicles
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`   // When the job finished
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Data quality of one dataset in an ingest job
+type QualityReport struct {
+	ID         int                `db:"id, primaryKey" json:"-"`    // Our ID
+	Updated    time.Time          `db:"updated, autoSet" json:"-"`  // Our update timestamp
+	JobID      int                `db:"job_id" json:"-"`            // Job the report was made in
+	Dataset    string             `db:"dataset" json:"dataset"`     // vehicles or emissions
+	Checked    int                `db:"checked" json:"checked"`     // Records checked
+	Violating  int                `db:"violating" json:"violating"` // Records breaking at least one rule
+	Aborted    bool               `db:"aborted" json:"aborted"`     // Whether the ingest was aborted over the threshold
+	Violations []QualityViolation `db:"-" json:"violations"`        // Per rule, most broken first
+}
+
+// Records breaking a rule in a quality report
+type QualityViolation struct {
+	ID               int       `db:"id, primaryKey" json:"-"`        // Our ID
+	Updated          time.Time `db:"updated, autoSet" json:"-"`      // Our update timestamp
+	JobID            int       `db:"job_id" json:"-"`                // Job the report was made in
+	Dataset          string    `db:"dataset" json:"-"`               // vehicles or emissions
+	Rule             string    `db:"rule" json:"rule"`               // Rule name, see QualityRule
+	Description      string    `db:"description" json:"description"` // What the rule checks
+	Count            int       `db:"count" json:"count"`             // Records breaking the rule
+	SampleEpaIDsText string    `db:"sample_epa_ids" json:"-"`        // Comma separated SampleEpaIDs
+	SampleEpaIDs     []int     `db:"-" json:"sampleEpaIds"`          // Up to QualitySampleSize breaking records
+}
+
+type QualityRule struct {
+	Name        string
+	Description string
+}
+
+var (
+	// EPA ids kept per violated rule
+	QualitySampleSize int = 10
+
+	// Plausible MPG (or MPGe) range
+	MinPlausibleMpg float64 = 5.0
+	MaxPlausibleMpg float64 = 250.0
+
+	// Fuel types the EPA uses
+	KnownFuelTypes []string = []string{"Diesel", "E85", "Electricity", "Hydrogen",
+		"Midgrade Gasoline", "Natural Gas", "Premium Gasoline", "Propane", "Regular Gasoline"}
+
+	MalformedNumberRule QualityRule = QualityRule{"malformed_number",
+		"Numeric fields that don't parse, which are stored as zero"}
+	MalformedRecordRule QualityRule = QualityRule{"malformed_record",
+		"Records that couldn't be converted and were skipped"}
+	MissingMakeModelRule QualityRule = QualityRule{"missing_make_model",
+		"Make or model is empty"}
+	MpgOrderRule QualityRule = QualityRule{"mpg_order",
+		"Combined MPG isn't between city and highway MPG, or city MPG is above highway MPG for a conventional vehicle"}
+	MpgOutOfBoundsRule QualityRule = QualityRule{"mpg_out_of_bounds",
+		fmt.Sprintf("City, combined or highway MPG outside %.0f to %.0f", MinPlausibleMpg, MaxPlausibleMpg)}
+	UnknownFuelTypeRule QualityRule = QualityRule{"unknown_fuel_type",
+		"Fuel type isn't a known EPA fuel type"}
+	UnparseableDateRule QualityRule = QualityRule{"unparseable_date",
+		"Created or modified date doesn't parse, which is stored as the zero time"}
+)
+
+func NewQualityReport(dataset string) *QualityReport {
+	return &QualityReport{Dataset: dataset, Violations: make([]QualityViolation, 0)}
+}
+
+// Counts a checked record and the rules it breaks
+func (r *QualityReport) AddRecord(epaID int, broken []QualityRule) {
+	r.Checked++
+	if len(broken) == 0 {
+		return
+	}
+	r.Violating++
+	for _, rule := range broken {
+		i := 0
+		for ; i < len(r.Violations); i++ {
+			if r.Violations[i].Rule == rule.Name {
+				break
+			}
+		}
+		if i == len(r.Violations) {
+			r.Violations = append(r.Violations, QualityViolation{
+				Dataset:      r.Dataset,
+				Rule:         rule.Name,
+				Description:  rule.Description,
+				SampleEpaIDs: make([]int, 0),
+			})
+		}
+		v := &r.Violations[i]
+		v.Count++
+		if len(v.SampleEpaIDs) < QualitySampleSize {
+			v.SampleEpaIDs = append(v.SampleEpaIDs, epaID)
+		}
+	}
+}
+
+func (r *QualityReport) ViolatingShare() float64 {
+	if r.Checked == 0 {
+		return 0.0
+	}
+	return float64(r.Violating) / float64(r.Checked)
+}
+
+// Rules a raw vehicle and the vehicle converted from it break
+func CheckVehicleQuality(raw *RawVehicle, v *Vehicle) []QualityRule {
+	broken := make([]QualityRule, 0)
+	if hasMalformedNumber(raw, reflect.TypeOf(*v)) {
+		broken = append(broken, MalformedNumberRule)
+	}
+	if strings.TrimSpace(v.Make) == "" || strings.TrimSpace(v.Model) == "" {
+		broken = append(broken, MissingMakeModelRule)
+	}
+	for _, date := range []string{raw.EpaCreatedOn, raw.EpaModifiedOn} {
+		if date != "" && parseStringToTime(date).IsZero() {
+			broken = append(broken, UnparseableDateRule)
+			break
+		}
+	}
+
+	mpgs := [][3]float64{{v.F1MpgCity, v.F1MpgComb, v.F1MpgHighway}}
+	if v.F2FuelType != "" {
+		mpgs = append(mpgs, [3]float64{v.F2MpgCity, v.F2MpgComb, v.F2MpgHighway})
+	}
+	outOfBounds, misordered := false, false
+	conventional := !containsString(ElectrifiedAtvTypes, v.AtvType)
+	for _, mpg := range mpgs {
+		city, comb, highway := mpg[0], mpg[1], mpg[2]
+		for _, val := range mpg {
+			if val < MinPlausibleMpg || val > MaxPlausibleMpg {
+				outOfBounds = true
+			}
+		}
+		// Rounded values may be off by one
+		if comb < math.Min(city, highway)-1 || comb > math.Max(city, highway)+1 ||
+			(conventional && city > highway) {
+			misordered = true
+		}
+	}
+	if outOfBounds {
+		broken = append(broken, MpgOutOfBoundsRule)
+	}
+	if misordered {
+		broken = append(broken, MpgOrderRule)
+	}
+
+	if !containsString(KnownFuelTypes, v.F1FuelType) ||
+		(v.F2FuelType != "" && !containsString(KnownFuelTypes, v.F2FuelType)) {
+		broken = append(broken, UnknownFuelTypeRule)
+	}
+	return broken
+}
+
+// Rules a raw emissions info and the result of converting it break. ei is
+// nil if conversion failed.
+func CheckEmissionsInfoQuality(raw *RawEmissionsInfo, ei *EmissionsInfo, err error) []QualityRule {
+	if err != nil || ei == nil {
+		return []QualityRule{MalformedRecordRule}
+	}
+	broken := make([]QualityRule, 0)
+	if hasMalformedNumber(raw, reflect.TypeOf(*ei)) {
+		broken = append(broken, MalformedNumberRule)
+	}
+	return broken
+}
+
+// Whether any raw string bound for a numeric field isn't blank or a number
+func hasMalformedNumber(raw interface{}, outType reflect.Type) bool {
+	rawVal := reflect.ValueOf(raw).Elem()
+	for i := 0; i < rawVal.NumField(); i++ {
+		rawField := rawVal.Type().Field(i)
+		if rawField.Tag.Get("parseBool") != "" {
+			continue
+		}
+		outField, ok := outType.FieldByName(rawField.Name)
+		if !ok {
+			continue
+		}
+		val := strings.TrimSpace(rawVal.Field(i).String())
+		if val == "" {
+			continue
+		}
+		switch outField.Type.Kind() {
+		case reflect.Int:
+			if _, err := strconv.ParseInt(val, 10, 64); err != nil {
+				return true
+			}
+		case reflect.Float64:
+			if _, err := strconv.ParseFloat(val, 64); err != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *QualityViolation) FillSampleEpaIDs() {
+	v.SampleEpaIDs = make([]int, 0)
+	for _, id := range strings.Split(v.SampleEpaIDsText, ",") {
+		if n, err := strconv.Atoi(id); err == nil {
+			v.SampleEpaIDs = append(v.SampleEpaIDs, n)
+		}
+	}
+}
+
+func (v *QualityViolation) FillSampleEpaIDsText() {
+	ids := make([]string, len(v.SampleEpaIDs))
+	for i, id := range v.SampleEpaIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	v.SampleEpaIDsText = strings.Join(ids, ",")
+}
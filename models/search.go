@@ -0,0 +1,108 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type SearchTerm struct {
+	ID        int    `db:"id, primaryKey" json:"-"` // Our ID
+	Term      string `db:"term" json:"term"`        // Normalised token found in vehicle search documents
+	Frequency int    `db:"frequency" json:"-"`      // Number of vehicles whose document contains the term
+}
+
+// Splits text into lowercase alphanumeric tokens. Hyphenated words and words
+// mixing letters and digits also yield their joined and split forms, so that
+// "F-150", "F150" and "f 150" all share tokens.
+func SearchTokens(text string) []string {
+	seen := make(map[string]bool)
+	tokens := make([]string, 0)
+	add := func(token string) {
+		if token != "" && !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		parts := strings.FieldsFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, part := range parts {
+			add(part)
+			for _, piece := range splitLettersAndDigits(part) {
+				add(piece)
+			}
+		}
+		if strings.Contains(word, "-") {
+			add(strings.Join(parts, ""))
+		}
+	}
+
+	return tokens
+}
+
+// Builds the normalised document the search indexes are built from
+func NewSearchDocument(v *Vehicle) string {
+	fields := []string{v.Make, v.Model, v.BaseModel, v.EngDscr, v.TransDscr,
+		v.Transition, strconv.Itoa(v.Year)}
+	return strings.Join(SearchTokens(strings.Join(fields, " ")), " ")
+}
+
+// Levenshtein distance between two tokens, used to accept typos in queries
+func EditDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Number of typos tolerated for a token of the given length
+func MaxTypos(token string) int {
+	switch n := len([]rune(token)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func splitLettersAndDigits(token string) []string {
+	pieces := make([]string, 0)
+	start := 0
+	runes := []rune(token)
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsDigit(runes[i]) != unicode.IsDigit(runes[i-1]) {
+			pieces = append(pieces, string(runes[start:i]))
+			start = i
+		}
+	}
+	if start == 0 {
+		return nil
+	}
+	return append(pieces, string(runes[start:]))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
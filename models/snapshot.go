@@ -0,0 +1,45 @@
+package models
+
+import (
+	"regexp"
+	"time"
+)
+
+const SnapshotVersionLayout string = "20060102T150405Z"
+
+// Snapshot versions are UTC creation times, e.g. 20261019T120000Z
+var SnapshotVersionPattern *regexp.Regexp = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z$`)
+
+// Describes a full dataset snapshot: a standalone SQLite database built with
+// the sqlite3 migrations, plus a gzipped NDJSON file per table
+type SnapshotManifest struct {
+	Version   string          `json:"version"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Tables    []SnapshotTable `json:"tables"`
+	Files     []SnapshotFile  `json:"files"`
+}
+
+type SnapshotTable struct {
+	Name string `json:"name"`
+	Rows int    `json:"rows"`
+}
+
+type SnapshotFile struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	Sha256 string `json:"sha256"` // Hex encoded checksum of the file as served
+	Url    string `json:"url,omitempty"`
+}
+
+func NewSnapshotVersion(t time.Time) string {
+	return t.UTC().Format(SnapshotVersionLayout)
+}
+
+func (m *SnapshotManifest) File(name string) (SnapshotFile, bool) {
+	for _, file := range m.Files {
+		if file.Name == name {
+			return file, true
+		}
+	}
+	return SnapshotFile{}, false
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Summary of one metric over a group of vehicles, precomputed after ingest
+type VehicleStat struct {
+	ID       int       `db:"id, primaryKey" json:"-"`    // Our ID
+	Updated  time.Time `db:"updated, autoSet" json:"-"`  // Our update timestamp
+	GroupBy  string    `db:"group_by" json:"-"`          // Grouping name, see StatisticsGroupings
+	GroupKey string    `db:"group_key" json:"-"`         // Group value, e.g. 2016 when grouping by year
+	Metric   string    `db:"metric" json:"-"`            // Metric name, see StatisticsMetrics
+	Count    int       `db:"count" json:"count"`         // Vehicles in group with data for the metric
+	Min      float64   `db:"min_value" json:"min"`       // Minimum
+	Max      float64   `db:"max_value" json:"max"`       // Maximum
+	Mean     float64   `db:"mean_value" json:"mean"`     // Mean
+	Median   float64   `db:"median_value" json:"median"` // Median
+	P10      float64   `db:"p10_value" json:"p10"`       // 10th percentile
+	P25      float64   `db:"p25_value" json:"p25"`       // 25th percentile
+	P75      float64   `db:"p75_value" json:"p75"`       // 75th percentile
+	P90      float64   `db:"p90_value" json:"p90"`       // 90th percentile
+}
+
+// Percentile ranks of a vehicle among vehicles of the same year and size
+// class. A percentile of 90 means the vehicle does as well as or better than
+// 90% of its group, whichever direction better is for the metric.
+type VehicleRanking struct {
+	ID                 int       `db:"id, primaryKey" json:"-"`                                  // Our ID
+	Updated            time.Time `db:"updated, autoSet" json:"-"`                                // Our update timestamp
+	EpaID              int       `db:"epa_id" json:"-"`                                          // vehicle record id
+	Year               int       `db:"year" json:"year"`                                         // model year of the group
+	SizeClass          string    `db:"size_class" json:"sizeClass"`                              // EPA vehicle size class of the group
+	GroupSize          int       `db:"group_size" json:"groupSize"`                              // Vehicles in group
+	MpgPercentile      float64   `db:"mpg_percentile" json:"mpgPercentile,omitempty"`            // Higher combined MPG is better
+	Co2Percentile      float64   `db:"co2_percentile" json:"co2Percentile,omitempty"`            // Lower tailpipe CO2 is better
+	FuelCostPercentile float64   `db:"fuel_cost_percentile" json:"fuelCostPercentile,omitempty"` // Lower annual fuel cost is better
+}
+
+type statisticsMetric struct {
+	Value          func(Fuel) float64
+	HigherIsBetter bool
+}
+
+var (
+	// Groupings statistics are computed over, keyed by name
+	StatisticsGroupings map[string]func(*Vehicle) string = map[string]func(*Vehicle) string{
+		"fuelType":  func(v *Vehicle) string { return v.FuelType },
+		"make":      func(v *Vehicle) string { return v.Make },
+		"sizeClass": func(v *Vehicle) string { return v.SizeClass },
+		"year":      func(v *Vehicle) string { return strconv.Itoa(v.Year) },
+	}
+
+	// Metrics computed from a vehicle's primary fuel under the default profile
+	StatisticsMetrics map[string]statisticsMetric = map[string]statisticsMetric{
+		"co2":      statisticsMetric{func(f Fuel) float64 { return f.Co2Tailpipe }, false},
+		"fuelCost": statisticsMetric{func(f Fuel) float64 { return float64(f.FuelCost) }, false},
+		"mpg":      statisticsMetric{func(f Fuel) float64 { return f.MpgComb }, true},
+	}
+)
+
+// Vehicles must have fuel data calculated. Zero values are missing data and
+// are left out.
+func NewVehicleStats(groupBy string, vehicles []Vehicle) []VehicleStat {
+	groupKey := StatisticsGroupings[groupBy]
+	values := make(map[string]map[string][]float64)
+	for i := range vehicles {
+		v := &vehicles[i]
+		key := groupKey(v)
+		if _, ok := values[key]; !ok {
+			values[key] = make(map[string][]float64)
+		}
+		for name, metric := range StatisticsMetrics {
+			if val := metric.Value(v.Fuels[0]); val > 0.0 {
+				values[key][name] = append(values[key][name], val)
+			}
+		}
+	}
+
+	stats := make([]VehicleStat, 0)
+	for key, metrics := range values {
+		for name, vals := range metrics {
+			sort.Float64s(vals)
+			stats = append(stats, VehicleStat{
+				GroupBy:  groupBy,
+				GroupKey: key,
+				Metric:   name,
+				Count:    len(vals),
+				Min:      vals[0],
+				Max:      vals[len(vals)-1],
+				Mean:     toFixed(mean(vals), 2),
+				Median:   toFixed(Percentile(vals, 50), 2),
+				P10:      toFixed(Percentile(vals, 10), 2),
+				P25:      toFixed(Percentile(vals, 25), 2),
+				P75:      toFixed(Percentile(vals, 75), 2),
+				P90:      toFixed(Percentile(vals, 90), 2),
+			})
+		}
+	}
+	return stats
+}
+
+// Ranks vehicles within their year and size class. Vehicles must have fuel
+// data calculated.
+func NewVehicleRankings(vehicles []Vehicle) []VehicleRanking {
+	type groupKey struct {
+		year      int
+		sizeClass string
+	}
+	groups := make(map[groupKey][]*Vehicle)
+	for i := range vehicles {
+		v := &vehicles[i]
+		key := groupKey{v.Year, v.SizeClass}
+		groups[key] = append(groups[key], v)
+	}
+
+	rankings := make([]VehicleRanking, 0, len(vehicles))
+	for key, group := range groups {
+		sorted := make(map[string][]float64)
+		for name, metric := range StatisticsMetrics {
+			for _, v := range group {
+				if val := metric.Value(v.Fuels[0]); val > 0.0 {
+					sorted[name] = append(sorted[name], val)
+				}
+			}
+			sort.Float64s(sorted[name])
+		}
+		for _, v := range group {
+			ranking := VehicleRanking{
+				EpaID:     v.EpaID,
+				Year:      key.year,
+				SizeClass: key.sizeClass,
+				GroupSize: len(group),
+			}
+			rank := func(name string) float64 {
+				metric := StatisticsMetrics[name]
+				val := metric.Value(v.Fuels[0])
+				if val <= 0.0 {
+					return 0.0
+				}
+				return toFixed(PercentileRank(sorted[name], val, metric.HigherIsBetter), 1)
+			}
+			ranking.MpgPercentile = rank("mpg")
+			ranking.Co2Percentile = rank("co2")
+			ranking.FuelCostPercentile = rank("fuelCost")
+			rankings = append(rankings, ranking)
+		}
+	}
+	return rankings
+}
+
+// Linearly interpolated percentile p (0-100) of sorted values
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0.0
+	}
+	pos := p / 100.0 * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+// Percent of sorted values that val is better than, counting ties as half
+func PercentileRank(sorted []float64, val float64, higherIsBetter bool) float64 {
+	below := sort.SearchFloat64s(sorted, val)
+	above := len(sorted) - sort.Search(len(sorted), func(i int) bool { return sorted[i] > val })
+	equal := len(sorted) - below - above
+	worse := below
+	if !higherIsBetter {
+		worse = above
+	}
+	return (float64(worse) + 0.5*float64(equal)) / float64(len(sorted)) * 100.0
+}
+
+func mean(vals []float64) float64 {
+	sum := 0.0
+	for _, val := range vals {
+		sum += val
+	}
+	return sum / float64(len(vals))
+}
@@ -0,0 +1,33 @@
+package models
+
+// Efficiency of one model year of a make and model, or of the whole market
+type TrendPoint struct {
+	Year                 int     `db:"group_key" json:"year"`                          // model year
+	Count                int     `db:"count" json:"count"`                             // Vehicles in year
+	BestMpg              float64 `db:"best_mpg" json:"bestMpg"`                        // Highest combined MPG for fuelType1
+	WorstMpg             float64 `db:"worst_mpg" json:"worstMpg"`                      // Lowest combined MPG for fuelType1
+	AvgMpg               float64 `db:"avg_mpg" json:"avgMpg"`                          // Mean combined MPG for fuelType1
+	BestCo2              float64 `db:"best_co2" json:"bestCo2"`                        // Lowest tailpipe CO2 in grams/mile for fuelType1
+	WorstCo2             float64 `db:"worst_co2" json:"worstCo2"`                      // Highest tailpipe CO2 in grams/mile for fuelType1
+	AvgCo2               float64 `db:"avg_co2" json:"avgCo2"`                          // Mean tailpipe CO2 in grams/mile for fuelType1
+	BestFuelCost         float64 `db:"best_fuel_cost" json:"bestFuelCost"`             // Lowest EPA annual fuel cost for fuelType1 ($)
+	WorstFuelCost        float64 `db:"worst_fuel_cost" json:"worstFuelCost"`           // Highest EPA annual fuel cost for fuelType1 ($)
+	AvgFuelCost          float64 `db:"avg_fuel_cost" json:"avgFuelCost"`               // Mean EPA annual fuel cost for fuelType1 ($)
+	AvgEngDisplacement   float64 `db:"avg_eng_displacement" json:"avgEngDisplacement"` // Mean engine displacement in liters
+	AvgCylinders         float64 `db:"avg_cylinders" json:"avgCylinders"`              // Mean engine cylinders
+	ElectrifiedCount     int     `db:"electrified_count" json:"-"`                     // Hybrid, plug-in hybrid and electric vehicles
+	ElectrifiedShare     float64 `db:"-" json:"electrifiedShare"`                      // Share of hybrid, plug-in hybrid and electric vehicles
+	ForcedInductionCount int     `db:"forced_induction_count" json:"-"`                // Turbocharged or supercharged vehicles
+	ForcedInductionShare float64 `db:"-" json:"forcedInductionShare"`                  // Share of turbocharged or supercharged vehicles
+}
+
+// ATV types counted as electrified
+var ElectrifiedAtvTypes []string = []string{"EV", "Hybrid", "Plug-in Hybrid"}
+
+func (t *TrendPoint) FillShares() {
+	if t.Count == 0 {
+		return
+	}
+	t.ElectrifiedShare = toFixed(float64(t.ElectrifiedCount)/float64(t.Count), 4)
+	t.ForcedInductionShare = toFixed(float64(t.ForcedInductionCount)/float64(t.Count), 4)
+}
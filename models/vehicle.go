@@ -5,10 +5,17 @@ import (
 	"time"
 )
 
+const (
+	VehicleActive    string = "active"
+	VehicleWithdrawn string = "withdrawn" // missing from the EPA feed
+	VehicleDeleted   string = "deleted"   // withdrawn for longer than the grace period
+)
+
 type Vehicle struct {
 	ID                    int             `db:"id, primaryKey" json:"-"`                                      // Our ID
 	Updated               time.Time       `db:"updated, autoSet" json:"updated"`                              // Our updated timestamp
 	AtvType               string          `db:"atv_type" json:"atvType,omitempty"`                            // type of alternative fuel or advanced technology vehicle
+	BaseModel             string          `db:"base_model" json:"baseModel,omitempty"`                        // base model name
 	ChargeTime120V        float64         `db:"charge_time_120v" json:"chargeTime120V,omitempty"`             // time to charge an electric vehicle in hours at 120 V
 	ChargeTime240V        float64         `db:"charge_time_240v" json:"chargeTime240V,omitempty"`             // time to charge an electric vehicle in hours at 240 V
 	ChargeTime240Vb       float64         `db:"charge_time_240vb" json:"chargeTime240Vb,omitempty"`           // time to charge an electric vehicle in hours at 240 V using the alternate charger
@@ -68,6 +75,7 @@ type Vehicle struct {
 	HasTurbocharger       bool            `db:"has_turbocharger" json:"hasTurbocharger,omitempty"`            // Parsed boolean
 	IsGuzzler             bool            `db:"is_guzzler" json:"isGuzzler,omitempty"`                        // Parsed boolean
 	IsPhevBlended         bool            `db:"is_phev_blended" json:"isPhevBlended,omitempty"`               // Parsed boolean
+	LastSeenInFeed        time.Time       `db:"last_seen_in_feed" json:"lastSeenInFeed"`                      // Our timestamp of the last vehicles feed the vehicle was in
 	LuggageVolume2Door    int             `db:"luggage_volume_2door" json:"luggageVolume2Door,omitempty"`     // 2 door luggage volume (cubic feet) (8)
 	LuggageVolume4Door    int             `db:"luggage_volume_4door" json:"luggageVolume4Door,omitempty"`     // 4 door luggage volume (cubic feet) (8)
 	LuggageVolumeHatch    int             `db:"luggage_volume_hatch" json:"luggageVolumeHatch,omitempty"`     // hatchback luggage volume (cubic feet) (8)
@@ -86,9 +94,13 @@ type Vehicle struct {
 	PhevUFCity            float64         `db:"phev_uf_city" json:"-"`                                        // EPA city utility factor (share of electricity) for PHEV
 	PhevUFComb            float64         `db:"phev_uf_comb" json:"-"`                                        // EPA combined utility factor (share of electricity) for PHEV
 	PhevUFHighway         float64         `db:"phev_uf_highway" json:"-"`                                     // EPA highway utility factor (share of electricity) for PHEV
+	Rankings              *VehicleRanking `db:"-" json:"rankings,omitempty"`                                  // Percentile ranks within year and size class, joined at application level
+	SearchDocument        string          `db:"search_document" json:"-"`                                     // Normalised tokens for full text search, see NewSearchDocument
 	SizeClass             string          `db:"size_class" json:"sizeClass,omitempty"`                        // EPA vehicle size class
+	Status                string          `db:"status" json:"status,omitempty"`                               // active, withdrawn from the EPA feed, or deleted once withdrawn past the grace period
 	TransDscr             string          `db:"trans_dscr" json:"transDscr,omitempty"`                        // transmission descriptor; see http://www.fueleconomy.gov/feg/findacarhelp.shtml#trany
 	Transition            string          `db:"transition" json:"transition,omitempty"`                       // transmission
+	WithdrawnAt           time.Time       `db:"withdrawn_at" json:"withdrawnAt"`                              // Our timestamp of when the vehicle was last withdrawn, which the grace period counts from
 	Year                  int             `db:"year" json:"year,omitempty"`                                   // model year
 	YouSaveSpend          int             `db:"you_save_spend" json:"-"`                                      // you save/spend over 5 years compared to an average car ($). Savings are positive; a greater amount spent yields a negative number. For dual fuel vehicles, this is the cost savings for gasoline
 }
@@ -98,7 +110,8 @@ type RawVehiclesOuter struct {
 }
 
 type RawVehicle struct {
-	AtvType               string `xml:"atvtype"`                                    // type of alternative fuel or advanced technology vehicle
+	AtvType               string `xml:"atvType"`                                    // type of alternative fuel or advanced technology vehicle
+	BaseModel             string `xml:"baseModel"`                                  // base model name
 	ChargeTime120V        string `xml:"charge120"`                                  // time to charge an electric vehicle in hours at 120 V
 	ChargeTime240V        string `xml:"charge240"`                                  // time to charge an electric vehicle in hours at 240 V
 	ChargeTime240Vb       string `xml:"charge240b"`                                 // time to charge an electric vehicle in hours at 240 V using the alternate charger
@@ -193,5 +206,7 @@ func NewVehicleFromRaw(raw *RawVehicle) (*Vehicle, error) {
 			return nil, err
 		}
 	}
+	vehicle.SearchDocument = NewSearchDocument(&vehicle)
+	vehicle.Status = VehicleActive
 	return &vehicle, nil
 }
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/teasherm/fueleconomy/models"
+)
+
+// Buckets are pruned once there are more than this many, dropping any that
+// have refilled, which are no different to a new bucket
+const memoryStorePruneSize int = 10000
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	plan    models.RateLimitPlan
+}
+
+// Keeps buckets in process, so each replica limits on its own
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *MemoryStore) Take(bucket string, plan models.RateLimitPlan, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[bucket]
+	if !ok {
+		if len(s.buckets) >= memoryStorePruneSize {
+			s.prune(now)
+		}
+		b = &memoryBucket{tokens: capacity(plan), updated: now}
+		s.buckets[bucket] = b
+	}
+	b.plan = plan
+	b.tokens = refill(plan, b.tokens, b.updated, now)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newResult(plan, b.tokens, allowed, now), nil
+}
+
+func (s *MemoryStore) prune(now time.Time) {
+	for name, b := range s.buckets {
+		if refill(b.plan, b.tokens, b.updated, now) >= capacity(b.plan) {
+			delete(s.buckets, name)
+		}
+	}
+}
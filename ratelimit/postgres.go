@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+// Keeps buckets in the rate_limit_buckets table, so replicas share them.
+// Each take is one statement, so concurrent takes can't overspend a bucket.
+type PostgresStore struct{}
+
+type postgresBucket struct {
+	Tokens  float64 `db:"tokens"`
+	Allowed bool    `db:"allowed"`
+}
+
+func NewPostgresStore() (*PostgresStore, error) {
+	if _, ok := global.Db.Dialect.(srm.PostgresDialect); !ok {
+		return nil, errors.New("ratelimit: the postgres store needs a postgres database")
+	}
+	return &PostgresStore{}, nil
+}
+
+func (s *PostgresStore) Take(bucket string, plan models.RateLimitPlan, now time.Time) (Result, error) {
+	refilled := "LEAST($2::double precision, b.tokens + " +
+		"GREATEST(EXTRACT(EPOCH FROM ($3 - b.updated_at)), 0) * $4::double precision)"
+	query := fmt.Sprintf(`INSERT INTO rate_limit_buckets AS b (bucket, tokens, allowed, updated_at)
+		VALUES ($1, $2::double precision - 1, true, $3)
+		ON CONFLICT (bucket) DO UPDATE SET
+			tokens = CASE WHEN %[1]s >= 1 THEN %[1]s - 1 ELSE %[1]s END,
+			allowed = %[1]s >= 1,
+			updated_at = $3
+		RETURNING tokens, allowed`, refilled)
+
+	b := &postgresBucket{}
+	err := global.Db.SelectOne(b, query, bucket, capacity(plan), now, rate(plan))
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(plan, b.Tokens, b.Allowed, now), nil
+}
+
+// Deletes buckets idle for long enough to have refilled under any plan, which
+// are no different to a new bucket, as MemoryStore.prune does. Nothing is
+// deleted while a plan never refills.
+func (s *PostgresStore) Prune(now time.Time) (int64, error) {
+	idle := time.Duration(0)
+	for _, plan := range global.RateLimitPlans {
+		if rate(plan) <= 0 {
+			return 0, nil
+		}
+		if refillTime := secondsDuration(capacity(plan) / rate(plan)); refillTime > idle {
+			idle = refillTime
+		}
+	}
+	query := fmt.Sprintf("DELETE FROM rate_limit_buckets WHERE updated_at < %s",
+		global.Db.Dialect.Placeholder(1))
+	result, err := global.Db.Exec(query, now.Add(-idle))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Prunes buckets every interval
+func (s *PostgresStore) StartPruner(interval time.Duration) {
+	go func() {
+		for now := range time.Tick(interval) {
+			_, err := s.Prune(now)
+			if err != nil {
+				global.Logger.Error("Rate limit bucket prune failed", "error", err)
+			}
+		}
+	}()
+}
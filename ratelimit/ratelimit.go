@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	"github.com/teasherm/fueleconomy/models"
+)
+
+// Where token buckets are kept. Take spends a token from the named bucket,
+// refilling it first for the time since it was last taken from.
+type Store interface {
+	Take(bucket string, plan models.RateLimitPlan, now time.Time) (Result, error)
+}
+
+// The store requests are limited with. The server swaps in a PostgresStore
+// when replicas need to share buckets.
+var Limiter Store = NewMemoryStore()
+
+// The outcome of taking a token
+type Result struct {
+	Allowed    bool          // Whether a token was taken
+	Limit      int           // Tokens the bucket holds when full
+	Remaining  int           // Whole tokens left
+	Reset      time.Time     // When the bucket will be full again
+	RetryAfter time.Duration // Until the next token, if not allowed
+}
+
+func capacity(plan models.RateLimitPlan) float64 {
+	if plan.Burst > 0 {
+		return float64(plan.Burst)
+	}
+	return float64(plan.RequestsPerMinute)
+}
+
+// Tokens per second
+func rate(plan models.RateLimitPlan) float64 {
+	return float64(plan.RequestsPerMinute) / 60.0
+}
+
+// Tokens in a bucket last left with tokens at updated
+func refill(plan models.RateLimitPlan, tokens float64, updated time.Time, now time.Time) float64 {
+	elapsed := now.Sub(updated).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(capacity(plan), tokens+elapsed*rate(plan))
+}
+
+// Describes a bucket left with tokens after a take
+func newResult(plan models.RateLimitPlan, tokens float64, allowed bool, now time.Time) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     int(capacity(plan)),
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     now,
+	}
+	r := rate(plan)
+	if r <= 0 {
+		return result
+	}
+	result.Reset = now.Add(secondsDuration((capacity(plan) - tokens) / r))
+	if !allowed {
+		result.RetryAfter = secondsDuration((1 - tokens) / r)
+	}
+	return result
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
@@ -1,25 +1,109 @@
 package srm
 
 import (
+	"context"
 	"database/sql"
+	"time"
 )
 
 // Struct Relational Mapper
 type DbMap struct {
 	Conn    *sql.DB
 	Dialect Dialect
+
+	// Called after each operation if set, e.g. to record metrics
+	Observe func(Operation)
+
+	ctx context.Context
+	tx  *sql.Tx
+}
+
+// What operations run statements on, Conn or the map's transaction
+type executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// A finished DbMap operation
+type Operation struct {
+	Context  context.Context // From WithContext, nil if not given one
+	Name     string          // The DbMap method, e.g. SelectMany
+	Table    string          // Table written to, empty for queries
+	Query    string          // SQL given to queries and Exec, with its placeholders
+	Rows     int             // Rows written by inserts and upserts
+	Duration time.Duration   // How long it took
+	Err      error           // The error it returned
+}
+
+// A DbMap whose operations are observed with ctx, e.g. so they're traced as
+// part of the request that ran them. It's only observed, queries aren't
+// cancelled with it.
+func (db *DbMap) WithContext(ctx context.Context) *DbMap {
+	withCtx := *db
+	withCtx.ctx = ctx
+	return &withCtx
+}
+
+// Runs fn with a copy of the map whose operations are in one transaction,
+// committed if fn returns nil and rolled back if it returns an error or
+// panics. opts may be nil for the driver's defaults. Called on a map already
+// in a transaction, fn joins it.
+func (db *DbMap) Transaction(opts *sql.TxOptions, fn func(tx *DbMap) error) (err error) {
+	if db.tx != nil {
+		return fn(db)
+	}
+	ctx := db.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx, err := db.Conn.BeginTx(ctx, opts)
+	if err != nil {
+		return db.translate(err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	withTx := *db
+	withTx.tx = tx
+	err = fn(&withTx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return db.translate(tx.Commit())
+}
+
+// Whether the map's operations are in a transaction, i.e. it's the one
+// Transaction handed its function
+func (db *DbMap) InTransaction() bool {
+	return db.tx != nil
+}
+
+func (db *DbMap) conn() executor {
+	if db.tx != nil {
+		return db.tx
+	}
+	return db.Conn
 }
 
 func (db *DbMap) DeleteAll(table string) (err error) {
+	defer db.observe("DeleteAll", table, "", 0, time.Now(), &err)
 	err = deleteall(db, table)
-	return err
+	return db.translate(err)
 }
 
 func (db *DbMap) InsertMany(table string, list ...interface{}) (insertedIds []int, err error) {
+	start := time.Now()
+	defer func() { db.observe("InsertMany", table, "", len(insertedIds), start, &err) }()
 	for _, ptr := range list {
 		insertedId, err := insert(db, table, ptr)
 		if err != nil {
-			return insertedIds, err
+			return insertedIds, db.translate(err)
 		}
 		insertedIds = append(insertedIds, insertedId)
 	}
@@ -27,46 +111,108 @@ func (db *DbMap) InsertMany(table string, list ...interface{}) (insertedIds []in
 }
 
 func (db *DbMap) InsertOne(table string, ptr interface{}) (insertedId int, err error) {
+	defer db.observe("InsertOne", table, "", 1, time.Now(), &err)
 	insertedId, err = insert(db, table, ptr)
-	return insertedId, err
+	return insertedId, db.translate(err)
 }
 
-func (db *DbMap) SelectInt(query string, args ...interface{}) (int, error) {
-	var h int64
-	err := selectval(db, &h, query, args...)
+func (db *DbMap) SelectInt(query string, args ...interface{}) (h int, err error) {
+	defer db.observe("SelectInt", "", query, 0, time.Now(), &err)
+	var val int64
+	err = selectval(db, &val, query, args...)
 	if err != nil && err != sql.ErrNoRows {
-		return 0, err
+		return 0, db.translate(err)
+	}
+	return int(val), nil
+}
+
+func (db *DbMap) SelectInts(query string, args ...interface{}) (ints []int, err error) {
+	defer db.observe("SelectInts", "", query, 0, time.Now(), &err)
+	var vals []int64
+	err = selectcolumn(db, &vals, query, args...)
+	ints = make([]int, 0, len(vals))
+	for _, val := range vals {
+		ints = append(ints, int(val))
 	}
-	return int(h), nil
+	return ints, db.translate(err)
 }
 
+func (db *DbMap) SelectStrings(query string, args ...interface{}) (strs []string, err error) {
+	defer db.observe("SelectStrings", "", query, 0, time.Now(), &err)
+	strs = make([]string, 0)
+	err = selectcolumn(db, &strs, query, args...)
+	return strs, db.translate(err)
+}
+
+// Returns an error of kind ErrNotFound if no row matches
 func (db *DbMap) SelectOne(ptr interface{}, query string, args ...interface{}) (err error) {
+	defer db.observe("SelectOne", "", query, 0, time.Now(), &err)
 	err = selectone(db, ptr, query, args...)
+	return db.translate(err)
+}
+
+// Streams rows from the database cursor, scanning each into the struct ptr
+// points to and calling fn before the next is read. Stops at fn's first error.
+// Its duration includes the time spent in fn.
+func (db *DbMap) SelectEach(ptr interface{}, fn func() error, query string, args ...interface{}) (err error) {
+	defer db.observe("SelectEach", "", query, 0, time.Now(), &err)
+	err = selecteach(db, ptr, fn, query, args...)
 	return err
 }
 
 func (db *DbMap) SelectMany(ptr interface{}, query string, args ...interface{}) (err error) {
+	defer db.observe("SelectMany", "", query, 0, time.Now(), &err)
 	err = selectmany(db, ptr, query, args...)
-	return err
+	return db.translate(err)
 }
 
 func (db *DbMap) UpdateOne(table string, updateOnField string, ptr interface{}) (rowsAffected int64, err error) {
+	defer db.observe("UpdateOne", table, "", 0, time.Now(), &err)
 	rowsAffected, err = update(db, table, updateOnField, ptr)
-	return rowsAffected, err
+	return rowsAffected, db.translate(err)
 }
 
 func (db *DbMap) UpsertOne(table string, updateOnField string, ptr interface{}) (insertedId int, err error) {
+	defer db.observe("UpsertOne", table, "", 1, time.Now(), &err)
 	insertedId, err = multiQueryUpsert(db, table, updateOnField, ptr)
-	return insertedId, err
+	return insertedId, db.translate(err)
 }
 
 func (db *DbMap) UpsertMany(table string, updateOnField string, list ...interface{}) (insertedIds []int, err error) {
+	start := time.Now()
+	defer func() { db.observe("UpsertMany", table, "", len(insertedIds), start, &err) }()
 	for _, ptr := range list {
 		insertedId, err := multiQueryUpsert(db, table, updateOnField, ptr)
 		if err != nil {
-			return insertedIds, err
+			return insertedIds, db.translate(err)
 		}
 		insertedIds = append(insertedIds, insertedId)
 	}
 	return insertedIds, nil
 }
+
+// Executes a statement that returns no rows, e.g. an UPDATE by a condition
+// UpdateOne can't express
+func (db *DbMap) Exec(query string, args ...interface{}) (result sql.Result, err error) {
+	defer db.observe("Exec", "", query, 0, time.Now(), &err)
+	result, err = db.conn().Exec(query, args...)
+	return result, db.translate(err)
+}
+
+func (db *DbMap) translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	return db.Dialect.TranslateError(err)
+}
+
+// Deferred with the named error result, so it sees the error returned
+func (db *DbMap) observe(name string, table string, query string, rows int, start time.Time, err *error) {
+	if db.Observe == nil {
+		return
+	}
+	if *err != nil {
+		rows = 0
+	}
+	db.Observe(Operation{db.ctx, name, table, query, rows, time.Since(start), *err})
+}
@@ -1,13 +1,21 @@
 package srm
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Dialect interface {
 	InsertQuerySuffix(string) string
 	Insert(*DbMap, string, ...interface{}) (int, error)
 	Placeholder(int) string
+	TextSearchCondition(string, *TextSearch, *int, *[]interface{}) string
+	TextSearchRank(string, *TextSearch, *int, *[]interface{}) string
+	TranslateError(error) error
 }
 
 type PostgresDialect struct{}
@@ -17,7 +25,7 @@ func (p PostgresDialect) InsertQuerySuffix(pkName string) string {
 }
 
 func (p PostgresDialect) Insert(db *DbMap, sqlString string, params ...interface{}) (insertedId int, err error) {
-	stmt, err := db.Conn.Prepare(sqlString)
+	stmt, err := db.conn().Prepare(sqlString)
 	if err != nil {
 		return insertedId, err
 	}
@@ -35,6 +43,68 @@ func (p PostgresDialect) Placeholder(count int) string {
 	return fmt.Sprintf("$%d", count)
 }
 
+// Matches the document's tsvector, falling back to trigram word similarity for
+// queries the tsquery misses. Expects gin indexes on to_tsvector('simple', document)
+// and on the document with gin_trgm_ops.
+func (p PostgresDialect) TextSearchCondition(table string, ts *TextSearch, count *int, args *[]interface{}) string {
+	cond := fmt.Sprintf("(to_tsvector('simple', %s.%s) @@ to_tsquery('simple', %s) OR %s <%% %s.%s)",
+		table, ts.Document, p.Placeholder(*count), p.Placeholder(*count+1), table, ts.Document)
+	*args = append(*args, p.tsquery(ts), ts.Query)
+	*count += 2
+	return cond
+}
+
+func (p PostgresDialect) TextSearchRank(table string, ts *TextSearch, count *int, args *[]interface{}) string {
+	rank := fmt.Sprintf("ts_rank(to_tsvector('simple', %s.%s), to_tsquery('simple', %s)) + word_similarity(%s, %s.%s) DESC",
+		table, ts.Document, p.Placeholder(*count), p.Placeholder(*count+1), table, ts.Document)
+	*args = append(*args, p.tsquery(ts), ts.Query)
+	*count += 2
+	return rank
+}
+
+// Errors by SQLSTATE, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+func (p PostgresDialect) TranslateError(err error) error {
+	if isConnectionError(err) {
+		return &Error{ErrConnection, err}
+	}
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return err
+	}
+	switch pqErr.Code {
+	case "23503":
+		return &Error{ErrForeignKeyViolation, err}
+	case "23505":
+		return &Error{ErrUniqueViolation, err}
+	case "53300", "57P01", "57P02", "57P03":
+		// Too many connections, or the server shutting down or starting up
+		return &Error{ErrConnection, err}
+	}
+	if pqErr.Code.Class() == "08" {
+		return &Error{ErrConnection, err}
+	}
+	return err
+}
+
+func (p PostgresDialect) tsquery(ts *TextSearch) string {
+	buff := bytes.Buffer{}
+	for i, alternatives := range ts.Terms {
+		if i > 0 {
+			buff.WriteString(" & ")
+		}
+		buff.WriteString("(")
+		for j, term := range alternatives {
+			if j > 0 {
+				buff.WriteString(" | ")
+			}
+			buff.WriteString(fmt.Sprintf("'%s':*", strings.Replace(term, "'", "''", -1)))
+		}
+		buff.WriteString(")")
+	}
+	return buff.String()
+}
+
 type Sqlite3Dialect struct{}
 
 func (s Sqlite3Dialect) InsertQuerySuffix(pkName string) string {
@@ -42,7 +112,7 @@ func (s Sqlite3Dialect) InsertQuerySuffix(pkName string) string {
 }
 
 func (p Sqlite3Dialect) Insert(db *DbMap, sqlString string, params ...interface{}) (insertedId int, err error) {
-	r, err := db.Conn.Exec(sqlString, params...)
+	r, err := db.conn().Exec(sqlString, params...)
 	if err != nil {
 		return insertedId, err
 	}
@@ -58,3 +128,69 @@ func (p Sqlite3Dialect) Insert(db *DbMap, sqlString string, params ...interface{
 func (s Sqlite3Dialect) Placeholder(count int) string {
 	return "?"
 }
+
+// Matches against an external content fts5 table named <table>_<document>_fts
+// whose rowid is the searched table's id
+func (s Sqlite3Dialect) TextSearchCondition(table string, ts *TextSearch, count *int, args *[]interface{}) string {
+	fts := s.ftsTable(table, ts)
+	cond := fmt.Sprintf("%s.id IN (SELECT rowid FROM %s WHERE %s MATCH %s)",
+		table, fts, fts, s.Placeholder(*count))
+	*args = append(*args, s.match(ts))
+	*count++
+	return cond
+}
+
+// fts5 rank is bm25, where lower is more relevant
+func (s Sqlite3Dialect) TextSearchRank(table string, ts *TextSearch, count *int, args *[]interface{}) string {
+	fts := s.ftsTable(table, ts)
+	rank := fmt.Sprintf("(SELECT rank FROM %s WHERE %s MATCH %s AND rowid = %s.id) ASC",
+		fts, fts, s.Placeholder(*count), table)
+	*args = append(*args, s.match(ts))
+	*count++
+	return rank
+}
+
+// Foreign key violations are only reported with foreign keys enabled on the
+// connection, e.g. with _foreign_keys=1 in the DSN
+func (s Sqlite3Dialect) TranslateError(err error) error {
+	if isConnectionError(err) {
+		return &Error{ErrConnection, err}
+	}
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok {
+		return err
+	}
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintForeignKey:
+		return &Error{ErrForeignKeyViolation, err}
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return &Error{ErrUniqueViolation, err}
+	}
+	switch sqliteErr.Code {
+	case sqlite3.ErrCantOpen, sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return &Error{ErrConnection, err}
+	}
+	return err
+}
+
+func (s Sqlite3Dialect) ftsTable(table string, ts *TextSearch) string {
+	return fmt.Sprintf("%s_%s_fts", table, ts.Document)
+}
+
+func (s Sqlite3Dialect) match(ts *TextSearch) string {
+	buff := bytes.Buffer{}
+	for i, alternatives := range ts.Terms {
+		if i > 0 {
+			buff.WriteString(" AND ")
+		}
+		buff.WriteString("(")
+		for j, term := range alternatives {
+			if j > 0 {
+				buff.WriteString(" OR ")
+			}
+			buff.WriteString(fmt.Sprintf("\"%s\"*", strings.Replace(term, "\"", "\"\"", -1)))
+		}
+		buff.WriteString(")")
+	}
+	return buff.String()
+}
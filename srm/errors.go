@@ -0,0 +1,52 @@
+package srm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+)
+
+// Kinds of database error, which each Dialect translates its driver's errors
+// into. Compare with ErrorKind or errors.Is.
+var (
+	ErrNotFound            error = errors.New("srm: not found")
+	ErrUniqueViolation     error = errors.New("srm: unique violation")
+	ErrForeignKeyViolation error = errors.New("srm: foreign key violation")
+	ErrConnection          error = errors.New("srm: connection failed")
+)
+
+// A driver error translated by a Dialect
+type Error struct {
+	Kind error // One of the srm error kinds
+	Err  error // The driver's error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// The srm error kind of err, nil if it wasn't translated
+func ErrorKind(err error) error {
+	if e, ok := err.(*Error); ok {
+		return e.Kind
+	}
+	return nil
+}
+
+// Errors from any driver meaning the database couldn't be reached
+func isConnectionError(err error) bool {
+	if err == driver.ErrBadConn || err == sql.ErrConnDone {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
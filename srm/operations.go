@@ -23,7 +23,7 @@ func multiQueryUpsert(db *DbMap, table string, updateOnField string, ptr interfa
 }
 
 func deleteall(db *DbMap, table string) error {
-	_, err := db.Conn.Exec(fmt.Sprintf("DELETE FROM %s", table))
+	_, err := db.conn().Exec(fmt.Sprintf("DELETE FROM %s", table))
 	if err != nil {
 		return err
 	}
@@ -117,7 +117,7 @@ func update(db *DbMap, table string, updateOnColumn string, ptr interface{}) (ro
 	queryBuffer.WriteString(fmt.Sprintf(" WHERE %s = %s;", updateOnColumn,
 		db.Dialect.Placeholder(count)))
 
-	stmt, err := db.Conn.Prepare(queryBuffer.String())
+	stmt, err := db.conn().Prepare(queryBuffer.String())
 	if err != nil {
 		return rowsAffected, err
 	}
@@ -9,10 +9,30 @@ import (
 type QueryBuilder struct {
 	Db         *DbMap
 	Table      string
+	Columns    []string // columns BuildSelect projects, all when empty
 	Limit      int
 	Offset     int
 	WhereExact map[string]interface{}
 	WhereFuzzy map[string]string
+	WhereIn    map[string][]interface{}
+	WhereRange map[string]Range
+	TextSearch *TextSearch
+}
+
+// Inclusive bounds on a column, nil for unbounded
+// SQL: WHERE col >= min AND col <= max
+type Range struct {
+	Min interface{}
+	Max interface{}
+}
+
+// Full text search over a table. Terms are ANDed together and each term
+// matches any one of its alternatives as a prefix. Results are ordered by
+// relevance.
+type TextSearch struct {
+	Document string     // column holding the normalised search document
+	Query    string     // normalised query string, used for similarity ranking
+	Terms    [][]string // query terms, each with its accepted alternatives
 }
 
 func (qb *QueryBuilder) BuildCount() (string, []interface{}) {
@@ -31,12 +51,17 @@ func (qb *QueryBuilder) BuildCount() (string, []interface{}) {
 func (qb *QueryBuilder) BuildSelect() (string, []interface{}) {
 	var sqlArgs []interface{}
 	sqlQuery := bytes.Buffer{}
-	sqlQuery.WriteString("SELECT * FROM ")
+	sqlQuery.WriteString(fmt.Sprintf("SELECT %s FROM ", qb.buildColumns()))
 	sqlQuery.WriteString(qb.Table)
 	first := true
 	count := 1
 	sqlQuery.WriteString(qb.buildWhere(&count, &first, &sqlArgs))
 
+	if qb.TextSearch != nil && len(qb.TextSearch.Terms) > 0 {
+		sqlQuery.WriteString(" ORDER BY ")
+		sqlQuery.WriteString(qb.Db.Dialect.TextSearchRank(qb.Table, qb.TextSearch, &count, &sqlArgs))
+	}
+
 	if qb.Limit > 0 {
 		sqlQuery.WriteString(fmt.Sprintf(" LIMIT %s", qb.Db.Dialect.Placeholder(count)))
 		sqlArgs = append(sqlArgs, qb.Limit)
@@ -51,6 +76,56 @@ func (qb *QueryBuilder) BuildSelect() (string, []interface{}) {
 	return sqlQuery.String(), sqlArgs
 }
 
+// Aggregates the rows matching the builder's filters per value of the group
+// expression, which is selected as group_key alongside the aggregates
+func (qb *QueryBuilder) BuildGroupBy(group string, aggregates ...string) (string, []interface{}) {
+	var sqlArgs []interface{}
+	sqlQuery := bytes.Buffer{}
+	sqlQuery.WriteString(fmt.Sprintf("SELECT %s AS group_key", group))
+	for _, aggregate := range aggregates {
+		sqlQuery.WriteString(", ")
+		sqlQuery.WriteString(aggregate)
+	}
+	sqlQuery.WriteString(" FROM ")
+	sqlQuery.WriteString(qb.Table)
+	first := true
+	count := 1
+	sqlQuery.WriteString(qb.buildWhere(&count, &first, &sqlArgs))
+	sqlQuery.WriteString(fmt.Sprintf(" GROUP BY %s ORDER BY %s", group, group))
+
+	return sqlQuery.String(), sqlArgs
+}
+
+func (qb *QueryBuilder) BuildDistinct(columns ...string) (string, []interface{}) {
+	var sqlArgs []interface{}
+	sqlQuery := bytes.Buffer{}
+	cols := strings.Join(columns, ", ")
+	sqlQuery.WriteString(fmt.Sprintf("SELECT DISTINCT %s FROM ", cols))
+	sqlQuery.WriteString(qb.Table)
+	first := true
+	count := 1
+	sqlQuery.WriteString(qb.buildWhere(&count, &first, &sqlArgs))
+	sqlQuery.WriteString(fmt.Sprintf(" ORDER BY %s", cols))
+
+	if qb.Limit > 0 {
+		sqlQuery.WriteString(fmt.Sprintf(" LIMIT %s", qb.Db.Dialect.Placeholder(count)))
+		sqlArgs = append(sqlArgs, qb.Limit)
+	}
+
+	return sqlQuery.String(), sqlArgs
+}
+
+func (qb *QueryBuilder) buildColumns() string {
+	if len(qb.Columns) == 0 {
+		return fmt.Sprintf("%s.*", qb.Table)
+	}
+	cols := make([]string, len(qb.Columns))
+	for i, col := range qb.Columns {
+		cols[i] = fmt.Sprintf("%s.%s", qb.Table, col)
+	}
+	return strings.Join(cols, ", ")
+}
+
 func (qb *QueryBuilder) buildWhere(count *int, first *bool, args *[]interface{}) string {
 	buff := bytes.Buffer{}
 	for col, val := range qb.WhereExact {
@@ -77,6 +152,57 @@ func (qb *QueryBuilder) buildWhere(count *int, first *bool, args *[]interface{})
 		*first = false
 		*count++
 	}
+	for col, vals := range qb.WhereIn {
+		if *first {
+			buff.WriteString(" WHERE ")
+		}
+		if !*first {
+			buff.WriteString(" AND ")
+		}
+		*first = false
+		if len(vals) == 0 {
+			// Nothing is in an empty list
+			buff.WriteString("1 = 0")
+			continue
+		}
+		placeholders := make([]string, len(vals))
+		for i, val := range vals {
+			placeholders[i] = qb.Db.Dialect.Placeholder(*count)
+			*args = append(*args, val)
+			*count++
+		}
+		buff.WriteString(fmt.Sprintf("%s IN (%s)", col, strings.Join(placeholders, ", ")))
+	}
+	for col, rng := range qb.WhereRange {
+		for _, bound := range []struct {
+			op  string
+			val interface{}
+		}{{">=", rng.Min}, {"<=", rng.Max}} {
+			if bound.val == nil {
+				continue
+			}
+			if *first {
+				buff.WriteString(" WHERE ")
+			}
+			if !*first {
+				buff.WriteString(" AND ")
+			}
+			buff.WriteString(fmt.Sprintf("%s %s %s", col, bound.op, qb.Db.Dialect.Placeholder(*count)))
+			*args = append(*args, bound.val)
+			*first = false
+			*count++
+		}
+	}
+	if qb.TextSearch != nil && len(qb.TextSearch.Terms) > 0 {
+		if *first {
+			buff.WriteString(" WHERE ")
+		}
+		if !*first {
+			buff.WriteString(" AND ")
+		}
+		buff.WriteString(qb.Db.Dialect.TextSearchCondition(qb.Table, qb.TextSearch, count, args))
+		*first = false
+	}
 	return buff.String()
 }
 
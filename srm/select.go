@@ -8,7 +8,7 @@ import (
 func selectone(db *DbMap, ptr interface{}, query string, args ...interface{}) error {
 	structVal := reflect.Indirect(reflect.ValueOf(ptr))
 
-	rows, err := db.Conn.Query(query, args...)
+	rows, err := db.conn().Query(query, args...)
 	if err != nil {
 		return err
 	}
@@ -35,7 +35,12 @@ func selectone(db *DbMap, ptr interface{}, query string, args ...interface{}) er
 		dest[x] = target
 	}
 
-	rows.Next()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return &Error{ErrNotFound, sql.ErrNoRows}
+	}
 	err = rows.Scan(dest...)
 	if err != nil {
 		return err
@@ -53,7 +58,7 @@ func selectmany(db *DbMap, ptr interface{}, query string, args ...interface{}) e
 	sliceVal := reflect.Indirect(reflect.ValueOf(ptr))
 	structType := reflect.TypeOf(ptr).Elem().Elem()
 
-	rows, err := db.Conn.Query(query, args...)
+	rows, err := db.conn().Query(query, args...)
 	if err != nil {
 		return err
 	}
@@ -101,8 +106,51 @@ func selectmany(db *DbMap, ptr interface{}, query string, args ...interface{}) e
 	return nil
 }
 
+func selecteach(db *DbMap, ptr interface{}, fn func() error, query string, args ...interface{}) error {
+	structVal := reflect.Indirect(reflect.ValueOf(ptr))
+	zero := reflect.Zero(structVal.Type())
+
+	rows, err := db.conn().Query(query, args...)
+	if err != nil {
+		return db.translate(err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return db.translate(err)
+	}
+
+	colToFieldIndex := makeColToFieldIndex(structVal.Type(), cols)
+	dest := make([]interface{}, len(cols))
+	for x := range cols {
+		idx := colToFieldIndex[x]
+		if idx == nil {
+			var dummy dummyField
+			dest[x] = &dummy
+			continue
+		}
+		dest[x] = structVal.FieldByIndex(idx).Addr().Interface()
+	}
+
+	for rows.Next() {
+		structVal.Set(zero)
+		err := rows.Scan(dest...)
+		if err != nil {
+			return db.translate(err)
+		}
+		// fn's errors are the caller's own, so aren't translated
+		err = fn()
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.translate(rows.Err())
+}
+
 func selectval(db *DbMap, holder interface{}, query string, args ...interface{}) error {
-	rows, err := db.Conn.Query(query, args...)
+	rows, err := db.conn().Query(query, args...)
 
 	if err != nil {
 		return err
@@ -115,3 +163,26 @@ func selectval(db *DbMap, holder interface{}, query string, args ...interface{})
 
 	return rows.Scan(holder)
 }
+
+// Scans the first column of each row into a slice of primitives
+func selectcolumn(db *DbMap, ptr interface{}, query string, args ...interface{}) error {
+	sliceVal := reflect.Indirect(reflect.ValueOf(ptr))
+	elemType := reflect.TypeOf(ptr).Elem().Elem()
+
+	rows, err := db.conn().Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		val := reflect.New(elemType)
+		err := rows.Scan(val.Interface())
+		if err != nil {
+			return err
+		}
+		sliceVal.Set(reflect.Append(sliceVal, val.Elem()))
+	}
+
+	return rows.Err()
+}
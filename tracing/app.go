@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+// Records an srm operation as a client span under the request or job it ran
+// for, if it was run through DbMap.WithContext. Nothing is recorded outside a
+// sampled span, so work not done for a traced request or job isn't traced.
+// system is the database, e.g. postgres.
+func ObserveDbOperation(system string, op srm.Operation) {
+	if op.Context == nil || !trace.SpanFromContext(op.Context).IsRecording() {
+		return
+	}
+	name := op.Name
+	if op.Table != "" {
+		name += " " + op.Table
+	}
+	attrs := []attribute.KeyValue{attribute.String("db.system", system), attribute.String("db.operation", op.Name)}
+	if op.Table != "" {
+		attrs = append(attrs, attribute.String("db.sql.table", op.Table), attribute.Int("db.rows", op.Rows))
+	}
+	if op.Query != "" {
+		attrs = append(attrs, attribute.String("db.statement", SanitiseSQL(op.Query)))
+	}
+	end := time.Now()
+	_, span := Tracer().Start(op.Context, name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(end.Add(-op.Duration)), trace.WithAttributes(attrs...))
+	SetError(span, op.Err)
+	span.End(trace.WithTimestamp(end))
+}
+
+const maxStatementLength int = 2048
+
+// Replaces string and number literals with ?, so values inlined into a query
+// aren't exported, and collapses whitespace. Placeholders such as $1 are kept.
+func SanitiseSQL(query string) string {
+	var out strings.Builder
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\'':
+			// '' is an escaped quote inside a literal
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			out.WriteRune('?')
+		case unicode.IsDigit(r) && (i == 0 || !isIdentRune(runes[i-1])):
+			for i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+			out.WriteRune('?')
+		case unicode.IsSpace(r):
+			for i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
+				i++
+			}
+			out.WriteRune(' ')
+		default:
+			out.WriteRune(r)
+		}
+	}
+	sanitised := strings.TrimSpace(out.String())
+	if len(sanitised) > maxStatementLength {
+		sanitised = sanitised[:maxStatementLength] + "..."
+	}
+	return sanitised
+}
+
+// Digits after these are part of a name or placeholder, e.g. co2 or $1
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '$'
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName string = "github.com/teasherm/fueleconomy"
+
+// Traces continue from and into traceparent headers whether or not spans are
+// exported, so a request's ID stays in its callers' trace
+func init() {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// Batches spans to exporter, sampling sampleRatio of new traces from 0 to 1.
+// Traces continued from a request are sampled as its traceparent says.
+func NewProvider(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "fueleconomy"))),
+	)
+}
+
+// The app's tracer, from the global provider. Its spans go nowhere until a
+// provider is set with otel.SetTracerProvider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Starts an internal span, a child of any span ctx is in. The returned
+// context is in the new span.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return StartKind(ctx, trace.SpanKindInternal, name, attrs...)
+}
+
+func StartKind(ctx context.Context, kind trace.SpanKind, name string,
+	attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Tracer().Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// Marks the span failed if err isn't nil
+func SetError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -1,6 +1,11 @@
 package workers
 
 import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
 	"github.com/teasherm/fueleconomy/global"
 )
 
@@ -8,11 +13,35 @@ var WorkQueue = make(chan WorkRequest, 1000)
 
 var WorkerQueue chan chan WorkRequest
 
+// Work requests taken off WorkQueue and waiting for a worker, and workers
+// running a job, out of nWorkers
+var (
+	nWaiting int64
+	nBusy    int64
+	nWorkers int64
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fueleconomy_workqueue_depth",
+		Help: "Work requests waiting for a worker.",
+	}, func() float64 { return float64(len(WorkQueue)) + float64(atomic.LoadInt64(&nWaiting)) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fueleconomy_workers_busy",
+		Help: "Workers running a job.",
+	}, func() float64 { return float64(atomic.LoadInt64(&nBusy)) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fueleconomy_workers_idle",
+		Help: "Workers waiting for a job.",
+	}, func() float64 { return float64(atomic.LoadInt64(&nWorkers) - atomic.LoadInt64(&nBusy)) })
+}
+
 func StartDispatcher(nworkers int) {
 	WorkerQueue = make(chan chan WorkRequest, nworkers)
+	atomic.AddInt64(&nWorkers, int64(nworkers))
 
 	for i := 0; i < nworkers; i++ {
-		global.Logger.Println("Starting worker", i+1)
+		global.Logger.Debug("Starting worker", "worker", i+1)
 		worker := NewWorker(i+1, WorkerQueue)
 		worker.Start()
 	}
@@ -21,10 +50,12 @@ func StartDispatcher(nworkers int) {
 		for {
 			select {
 			case work := <-WorkQueue:
-				global.Logger.Println("Received work request for:", work.Target)
+				workLogger(work).Debug("Work request received")
+				atomic.AddInt64(&nWaiting, 1)
 				go func() {
 					worker := <-WorkerQueue
-					global.Logger.Println("Dispatching work request for:", work.Target)
+					atomic.AddInt64(&nWaiting, -1)
+					workLogger(work).Debug("Work request dispatched")
 					worker <- work
 				}()
 			}
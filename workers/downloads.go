@@ -4,35 +4,38 @@ import (
 	"archive/zip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 )
 
-const (
-	FILE_URL   = "https://www.fueleconomy.gov/feg/epadata/%s.xml.zip"
-	UNZIP_PATH = "/tmp/%s"
-	ZIP_PATH   = "/tmp/%s.xml.zip"
-)
-
-func DownloadXml(name string) (string, error) {
-	zipUrl := fmt.Sprintf(FILE_URL, name)
-	zipPath := fmt.Sprintf(ZIP_PATH, name)
+const FILE_URL = "https://www.fueleconomy.gov/feg/epadata/%s.%s.zip"
 
-	unzipPath := fmt.Sprintf(UNZIP_PATH, name)
+// Downloads and unzips EPA's xml or csv release of a file into a new temp
+// directory, which the caller removes
+func DownloadData(name string, format string) (string, error) {
+	zipUrl := fmt.Sprintf(FILE_URL, name, format)
+	unzipPath, err := ioutil.TempDir("", name)
+	if err != nil {
+		return "", err
+	}
+	zipPath := filepath.Join(unzipPath, name+"."+format+".zip")
 
-	err := downloadFile(zipUrl, zipPath)
+	err = downloadFile(zipUrl, zipPath)
 	if err != nil {
+		os.RemoveAll(unzipPath)
 		return "", err
 	}
 	defer os.Remove(zipPath)
 
 	err = unzip(zipPath, unzipPath)
 	if err != nil {
+		os.RemoveAll(unzipPath)
 		return "", err
 	}
 
-	return fmt.Sprintf("%s/%s.xml", unzipPath, name), nil
+	return filepath.Join(unzipPath, name+"."+format), nil
 }
 
 func downloadFile(url string, fpath string) error {
@@ -46,6 +49,9 @@ func downloadFile(url string, fpath string) error {
 		}
 	}()
 	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
 	_, err = io.Copy(out, resp.Body)
 	if err != nil {
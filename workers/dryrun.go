@@ -0,0 +1,404 @@
+package workers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+var (
+	ErrNotAwaitingApproval error = errors.New("Job is not a dry run awaiting approval")
+	ErrDryRunStale         error = errors.New("Data has been ingested since the dry run, dry run again")
+	ErrIngestPending       error = errors.New("An ingest is queued or running, approve once it's done")
+)
+
+// Compares a vehicles release, and its emissions info, against the stored
+// data and records the changes ingesting it would make. Nothing else is
+// written until the job is approved.
+func DryRunVehicles(f Fetcher) error {
+	job := jobOf(f)
+	if job == nil {
+		return runAsJob("vehicles", f, DryRunVehicles)
+	}
+
+	rawVehicles, vehicles, err := prepareVehicles(f, job)
+	if err != nil {
+		return err
+	}
+	stored := make(map[int]models.Vehicle)
+	active := 0
+	v := models.Vehicle{}
+	err = global.Db.SelectEach(&v, func() error {
+		stored[v.EpaID] = v
+		if v.Status == models.VehicleActive {
+			active++
+		}
+		return nil
+	}, "SELECT * FROM vehicles")
+	if err != nil {
+		return err
+	}
+
+	changes := make([]*models.IngestChange, 0)
+	for i, fv := range vehicles {
+		change := &models.IngestChange{Dataset: "vehicles", EpaID: fv.EpaID}
+		if old, ok := stored[fv.EpaID]; ok {
+			delete(stored, fv.EpaID)
+			change.Fields = models.DiffVehicles(&old, fv)
+			if len(change.Fields) == 0 {
+				continue
+			}
+			change.ChangeType = models.ChangeChanged
+		} else {
+			change.ChangeType = models.ChangeAdded
+		}
+		b, err := json.Marshal(rawVehicles[i])
+		if err != nil {
+			return err
+		}
+		change.Record = string(b)
+		changes = append(changes, change)
+	}
+
+	// A truncated release removes nothing, as an ingest withdraws nothing
+	complete := feedComplete(job, len(vehicles), active)
+	for epaID, old := range stored {
+		if !complete || old.Status != models.VehicleActive {
+			continue
+		}
+		changes = append(changes, &models.IngestChange{
+			Dataset: "vehicles", EpaID: epaID, ChangeType: models.ChangeRemoved})
+	}
+	err = saveIngestChanges(job, changes)
+	if err != nil {
+		return err
+	}
+	return dryRunEmissionsInfo(f, job)
+}
+
+// Emissions info counterpart of DryRunVehicles. Changes are per vehicle.
+func DryRunEmissionsInfo(f Fetcher) error {
+	job := jobOf(f)
+	if job == nil {
+		return runAsJob("emissions", f, DryRunEmissionsInfo)
+	}
+	return dryRunEmissionsInfo(f, job)
+}
+
+func dryRunEmissionsInfo(f Fetcher, job *models.Job) error {
+	raws, emissionsInfoes, err := prepareEmissionsInfoes(f, job)
+	if err != nil {
+		return err
+	}
+	stored := make(map[int][]models.EmissionsInfo)
+	ei := models.EmissionsInfo{}
+	err = global.Db.SelectEach(&ei, func() error {
+		stored[ei.EpaID] = append(stored[ei.EpaID], ei)
+		return nil
+	}, "SELECT * FROM emissions_info ORDER BY id")
+	if err != nil {
+		return err
+	}
+
+	// Group the release by vehicle, keeping release order
+	epaIDs := make([]int, 0)
+	released := make(map[int][]models.EmissionsInfo)
+	releasedRaws := make(map[int][]models.RawEmissionsInfo)
+	for i, ei := range emissionsInfoes {
+		if _, ok := released[ei.EpaID]; !ok {
+			epaIDs = append(epaIDs, ei.EpaID)
+		}
+		released[ei.EpaID] = append(released[ei.EpaID], *ei)
+		releasedRaws[ei.EpaID] = append(releasedRaws[ei.EpaID], raws[i])
+	}
+
+	changes := make([]*models.IngestChange, 0)
+	for _, epaID := range epaIDs {
+		change := &models.IngestChange{Dataset: "emissions", EpaID: epaID}
+		if old, ok := stored[epaID]; ok {
+			delete(stored, epaID)
+			change.Fields = models.DiffEmissionsInfoes(old, released[epaID])
+			if len(change.Fields) == 0 {
+				continue
+			}
+			change.ChangeType = models.ChangeChanged
+		} else {
+			change.ChangeType = models.ChangeAdded
+		}
+		b, err := json.Marshal(releasedRaws[epaID])
+		if err != nil {
+			return err
+		}
+		change.Record = string(b)
+		changes = append(changes, change)
+	}
+	for epaID := range stored {
+		changes = append(changes, &models.IngestChange{
+			Dataset: "emissions", EpaID: epaID, ChangeType: models.ChangeRemoved})
+	}
+	return saveIngestChanges(job, changes)
+}
+
+func saveIngestChanges(job *models.Job, changes []*models.IngestChange) error {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		change.JobID = job.ID
+		err := change.FillFieldsText()
+		if err != nil {
+			return err
+		}
+		_, err = global.Db.InsertOne("ingest_changes", change)
+		if err != nil {
+			return err
+		}
+		counts[change.ChangeType]++
+	}
+	jobLogger(job).Info("Dry run changes recorded", "added", counts[models.ChangeAdded],
+		"removed", counts[models.ChangeRemoved], "changed", counts[models.ChangeChanged])
+	return nil
+}
+
+// Queues applying a dry run's recorded changes. The dry run must be awaiting
+// approval, with nothing ingested since it finished and nothing being ingested.
+// Claiming the dry run and recording the approve job are one transaction on
+// db, joining the caller's if it's in one.
+func GenerateApprovalWorkRequest(db *srm.DbMap, dryRunJobID int) (WorkRequest, error) {
+	var work WorkRequest
+	err := db.Transaction(nil, func(tx *srm.DbMap) error {
+		var err error
+		work, err = approvalWorkRequest(tx, dryRunJobID)
+		return err
+	})
+	return work, err
+}
+
+func approvalWorkRequest(db *srm.DbMap, dryRunJobID int) (WorkRequest, error) {
+	dryRun, err := selectJob(db, dryRunJobID)
+	if err != nil {
+		return WorkRequest{}, err
+	}
+	if dryRun.Status != models.JobAwaitingApproval {
+		return WorkRequest{}, ErrNotAwaitingApproval
+	}
+
+	p := db.Dialect.Placeholder
+	query := fmt.Sprintf("SELECT COUNT(*) FROM jobs WHERE target IN ('vehicles', 'emissions', 'approve') "+
+		"AND status = %s AND dry_run = %s AND finished_at > %s", p(1), p(2), p(3))
+	ingested, err := db.SelectInt(query, models.JobSucceeded, false, dryRun.FinishedAt)
+	if err != nil {
+		return WorkRequest{}, err
+	}
+	if ingested > 0 {
+		return WorkRequest{}, ErrDryRunStale
+	}
+	query = fmt.Sprintf("SELECT COUNT(*) FROM jobs WHERE target IN ('vehicles', 'emissions', 'approve') "+
+		"AND status IN (%s, %s) AND dry_run = %s", p(1), p(2), p(3))
+	pending, err := db.SelectInt(query, models.JobQueued, models.JobRunning, false)
+	if err != nil {
+		return WorkRequest{}, err
+	}
+	if pending > 0 {
+		return WorkRequest{}, ErrIngestPending
+	}
+
+	// Only one approval can claim the dry run
+	query = fmt.Sprintf("UPDATE jobs SET status = %s WHERE id = %s AND status = %s", p(1), p(2), p(3))
+	result, err := db.Exec(query, models.JobApproved, dryRun.ID, models.JobAwaitingApproval)
+	if err != nil {
+		return WorkRequest{}, err
+	}
+	if claimed, err := result.RowsAffected(); err != nil || claimed == 0 {
+		return WorkRequest{}, ErrNotAwaitingApproval
+	}
+
+	job, err := NewJob(db, "approve", dryRun.Source, false)
+	if err != nil {
+		return WorkRequest{}, err
+	}
+	job.ApprovesID = dryRun.ID
+	query = fmt.Sprintf("UPDATE jobs SET approves_job_id = %s WHERE id = %s", p(1), p(2))
+	_, err = db.Exec(query, job.ApprovesID, job.ID)
+	if err != nil {
+		return WorkRequest{}, err
+	}
+
+	return WorkRequest{
+		Target: "approve",
+		Action: func(f Fetcher) error { return applyIngestChanges(job, dryRun) },
+		Job:    job}, nil
+}
+
+// Puts a claimed dry run back to awaiting approval, so it can be approved
+// again once whatever stopped its changes being applied is sorted
+func releaseDryRun(dryRun *models.Job) {
+	p := global.Db.Dialect.Placeholder
+	query := fmt.Sprintf("UPDATE jobs SET status = %s WHERE id = %s AND status = %s", p(1), p(2), p(3))
+	_, err := global.Db.Exec(query, models.JobAwaitingApproval, dryRun.ID, models.JobApproved)
+	if err != nil {
+		jobLogger(dryRun).Error("Failed to release dry run", "error", err)
+	}
+}
+
+// Applies exactly the changes a dry run recorded, all or none of them, then
+// rebuilds what's derived from vehicles. If they can't be applied the dry run
+// is awaiting approval again.
+func applyIngestChanges(job *models.Job, dryRun *models.Job) error {
+	seenAt := time.Now().UTC()
+	err := global.Db.Transaction(nil, func(tx *srm.DbMap) error {
+		return applyDryRunChanges(tx, job, dryRun, seenAt)
+	})
+	if err != nil {
+		releaseDryRun(dryRun)
+		return err
+	}
+
+	if dryRun.Target == "vehicles" {
+		searchDocuments, err := global.Db.SelectStrings("SELECT search_document FROM vehicles")
+		if err != nil {
+			return err
+		}
+		termFrequencies := make(map[string]int)
+		for _, doc := range searchDocuments {
+			for _, term := range strings.Fields(doc) {
+				termFrequencies[term]++
+			}
+		}
+		err = rebuildSearchTerms(job, termFrequencies)
+		if err != nil {
+			return err
+		}
+	}
+	err = deleteExpiredWithdrawals(job, seenAt)
+	if err != nil {
+		return err
+	}
+	err = ComputeStatistics(jobFetcher{job: job})
+	if err != nil {
+		return err
+	}
+	queueSnapshot()
+	return nil
+}
+
+// Writes a dry run's changes, and for vehicles what follows from them being
+// the whole release, through db
+func applyDryRunChanges(db *srm.DbMap, job *models.Job, dryRun *models.Job, seenAt time.Time) error {
+	changes := make([]models.IngestChange, 0)
+	query := fmt.Sprintf("SELECT * FROM ingest_changes WHERE job_id = %s ORDER BY id", db.Dialect.Placeholder(1))
+	err := db.SelectMany(&changes, query, dryRun.ID)
+	if err != nil {
+		return err
+	}
+
+	// Vehicles before the emissions info that references them
+	for _, dataset := range []string{"vehicles", "emissions"} {
+		for _, change := range changes {
+			if change.Dataset != dataset {
+				continue
+			}
+			if dataset == "vehicles" {
+				err = applyVehicleChange(db, &change, seenAt)
+			} else {
+				err = applyEmissionsInfoChange(db, job, &change)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if dryRun.Target == "vehicles" {
+		err = markVehiclesSeen(db, job, seenAt)
+		if err != nil {
+			return err
+		}
+		err = relinkEmissionsInfoOrphans(db, job)
+		if err != nil {
+			return err
+		}
+	}
+	jobLogger(job).Info("Dry run changes applied", "count", len(changes), "approves_job_id", dryRun.ID)
+	return nil
+}
+
+// Vehicles the release removed are withdrawn at seenAt, and those it added or
+// changed are seen in the feed then
+func applyVehicleChange(db *srm.DbMap, change *models.IngestChange, seenAt time.Time) error {
+	p := db.Dialect.Placeholder
+	if change.ChangeType == models.ChangeRemoved {
+		query := fmt.Sprintf("UPDATE vehicles SET status = %s, withdrawn_at = %s WHERE epa_id = %s",
+			p(1), p(2), p(3))
+		_, err := db.Exec(query, models.VehicleWithdrawn, seenAt, change.EpaID)
+		return err
+	}
+
+	var raw models.RawVehicle
+	err := json.Unmarshal([]byte(change.Record), &raw)
+	if err != nil {
+		return err
+	}
+	fv, err := models.NewVehicleFromRaw(&raw)
+	if err != nil {
+		return err
+	}
+	fv.LastSeenInFeed = seenAt
+	_, err = db.UpsertOne("vehicles", "epa_id", fv)
+	return err
+}
+
+// Once a vehicles dry run's changes are applied every active vehicle was in
+// its release: unchanged ones weren't recorded, and withdrawn ones that
+// reappeared were recorded as changed back to active
+func markVehiclesSeen(db *srm.DbMap, job *models.Job, seenAt time.Time) error {
+	p := db.Dialect.Placeholder
+	query := fmt.Sprintf("UPDATE vehicles SET last_seen_in_feed = %s WHERE status = %s", p(1), p(2))
+	result, err := db.Exec(query, seenAt, models.VehicleActive)
+	if err != nil {
+		return err
+	}
+	seen, _ := result.RowsAffected()
+	jobLogger(job).Info("Vehicles seen in feed", "count", seen)
+	return nil
+}
+
+func applyEmissionsInfoChange(db *srm.DbMap, job *models.Job, change *models.IngestChange) error {
+	err := deleteEmissionsInfo(db, change.EpaID)
+	if err != nil || change.ChangeType == models.ChangeRemoved {
+		return err
+	}
+
+	var raws []models.RawEmissionsInfo
+	err = json.Unmarshal([]byte(change.Record), &raws)
+	if err != nil {
+		return err
+	}
+	for i := range raws {
+		ei, err := models.NewEmissionsInfoFromRaw(&raws[i])
+		if err != nil {
+			return err
+		}
+		_, err = insertEmissionsInfo(db, job, &raws[i], ei)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes a vehicle's emissions info, orphaned or not
+func deleteEmissionsInfo(db *srm.DbMap, epaID int) error {
+	for _, table := range []string{"emissions_info", "emissions_info_orphans"} {
+		query := fmt.Sprintf("DELETE FROM %s WHERE epa_id = %s", table, db.Dialect.Placeholder(1))
+		_, err := db.Exec(query, epaID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,20 @@
 package workers
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+
+	"github.com/teasherm/fueleconomy/models"
 )
 
 type Fetcher interface {
@@ -28,23 +38,191 @@ func (r RestFetcher) Fetch(url string) ([]byte, error) {
 	return body, nil
 }
 
-type FileFetcher struct{}
+// Downloads EPA's zipped XML, or CSV if Format is "csv"
+type FileFetcher struct {
+	Format string
+}
 
 func (v FileFetcher) Fetch(fname string) ([]byte, error) {
-	xmlFilePath, err := DownloadXml(fname)
+	format := v.Format
+	if format == "" {
+		format = "xml"
+	}
+	dataFilePath, err := DownloadData(fname, format)
 	if err != nil {
 		return nil, err
 	}
-	defer os.RemoveAll(filepath.Dir(xmlFilePath))
-	xmlFile, err := os.Open(xmlFilePath)
+	defer os.RemoveAll(filepath.Dir(dataFilePath))
+	dataFile, err := os.Open(dataFilePath)
 	if err != nil {
 		return nil, err
 	}
-	defer xmlFile.Close()
-	b, err := ioutil.ReadAll(xmlFile)
+	defer dataFile.Close()
+	b, err := ioutil.ReadAll(dataFile)
 	if err != nil {
 		return nil, err
 	}
 
 	return b, nil
 }
+
+var (
+	// Files a local source may hold for each fetch name, plain or zipped
+	LocalSourceFiles map[string][]string = map[string][]string{
+		"vehicles":   []string{"vehicles.xml", "vehicles.csv"},
+		"emissions":  []string{"emissions.xml", "emissions.csv"},
+		"fuelprices": []string{"fuelprices.xml", "fuel_prices.xml"},
+	}
+
+	// Root elements identifying a lone XML document read from stdin
+	LocalSourceRoots map[string]string = map[string]string{
+		"vehicles":   "vehicles",
+		"emissions":  "emissionsInfoes",
+		"fuelprices": "fuelPrices",
+	}
+
+	// Columns identifying a lone CSV file read from stdin
+	LocalSourceCSVColumns map[string]string = map[string]string{
+		"vehicles":  "make",
+		"emissions": "efid",
+	}
+)
+
+// Reads EPA data from a directory, a zip archive, or "-" for stdin, which may
+// be a zip archive or a single XML document. Accepts the same names and URLs
+// the other fetchers do, e.g. "vehicles" or the fuel prices URL.
+type LocalFetcher struct {
+	Path  string
+	Stdin io.Reader
+
+	stdin []byte // read once and kept for later fetches
+}
+
+func NewLocalFetcher(path string) *LocalFetcher {
+	return &LocalFetcher{Path: path, Stdin: os.Stdin}
+}
+
+func (l *LocalFetcher) Fetch(target string) ([]byte, error) {
+	name := path.Base(strings.TrimSuffix(target, "/"))
+	fnames, ok := LocalSourceFiles[name]
+	if !ok {
+		return nil, fmt.Errorf("LocalFetcher: unknown data %s", target)
+	}
+
+	if l.Path == "-" {
+		return l.fetchStdin(name, fnames)
+	}
+	info, err := os.Stat(l.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		r, err := zip.OpenReader(l.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return l.fetchZip(&r.Reader, name, fnames)
+	}
+
+	for _, fname := range fnames {
+		b, err := ioutil.ReadFile(filepath.Join(l.Path, fname))
+		if err == nil || !os.IsNotExist(err) {
+			return b, err
+		}
+		r, err := zip.OpenReader(filepath.Join(l.Path, fname+".zip"))
+		if err == nil {
+			defer r.Close()
+			return l.fetchZip(&r.Reader, name, fnames)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("LocalFetcher: no %s data in %s", name, l.Path)
+}
+
+func (l *LocalFetcher) fetchStdin(name string, fnames []string) ([]byte, error) {
+	if l.stdin == nil {
+		b, err := ioutil.ReadAll(l.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		l.stdin = b
+	}
+
+	if bytes.HasPrefix(l.stdin, []byte("PK")) {
+		r, err := zip.NewReader(bytes.NewReader(l.stdin), int64(len(l.stdin)))
+		if err != nil {
+			return nil, err
+		}
+		return l.fetchZip(r, name, fnames)
+	}
+	if models.IsXML(l.stdin) && xmlRoot(l.stdin) == LocalSourceRoots[name] {
+		return l.stdin, nil
+	}
+	if !models.IsXML(l.stdin) && csvHasColumn(l.stdin, LocalSourceCSVColumns[name]) {
+		return l.stdin, nil
+	}
+	return nil, fmt.Errorf("LocalFetcher: no %s data in %s", name, l.sourceName())
+}
+
+// Finds the file in an archive, at any depth, unzipping a nested
+// <file>.zip as the EPA distributes them
+func (l *LocalFetcher) fetchZip(r *zip.Reader, name string, fnames []string) ([]byte, error) {
+	for _, fname := range fnames {
+		for _, f := range r.File {
+			base := path.Base(f.Name)
+			if base != fname && base != fname+".zip" {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil || base == fname {
+				return b, err
+			}
+			nested, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				return nil, err
+			}
+			return l.fetchZip(nested, name, fnames)
+		}
+	}
+	return nil, fmt.Errorf("LocalFetcher: no %s data in %s", name, l.sourceName())
+}
+
+func (l *LocalFetcher) sourceName() string {
+	if l.Path == "-" {
+		return "stdin"
+	}
+	return l.Path
+}
+
+func csvHasColumn(b []byte, column string) bool {
+	header, err := csv.NewReader(bytes.NewReader(b)).Read()
+	if err != nil || column == "" {
+		return false
+	}
+	for _, col := range header {
+		if col == column {
+			return true
+		}
+	}
+	return false
+}
+
+func xmlRoot(b []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
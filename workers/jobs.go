@@ -0,0 +1,196 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/teasherm/fueleconomy/cache"
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+	"github.com/teasherm/fueleconomy/tracing"
+)
+
+// Actions are handed their fetcher wrapped with the job they run under
+type jobFetcher struct {
+	Fetcher
+	job *models.Job
+	ctx context.Context // In the job's span
+}
+
+// Fetches in a span under the job's, so its trace shows the downloads
+func (f jobFetcher) Fetch(name string) ([]byte, error) {
+	_, span := tracing.StartKind(f.ctx, trace.SpanKindClient, "fetch "+name,
+		attribute.String("fetch.name", name), attribute.String("fetch.fetcher", fmt.Sprintf("%T", f.Fetcher)))
+	b, err := f.Fetcher.Fetch(name)
+	span.SetAttributes(attribute.Int("fetch.bytes", len(b)))
+	tracing.SetError(span, err)
+	span.End()
+	return b, err
+}
+
+// Logs with the job's ID and target, or without if job is nil
+func jobLogger(job *models.Job) *slog.Logger {
+	if job == nil {
+		return global.Logger
+	}
+	return global.Logger.With("job_id", job.ID, "target", job.Target)
+}
+
+// Logs with the work's target, and its job's ID once it has one
+func workLogger(work WorkRequest) *slog.Logger {
+	if work.Job == nil {
+		return global.Logger.With("target", work.Target)
+	}
+	return jobLogger(work.Job)
+}
+
+// Records a queued job
+func NewJob(db *srm.DbMap, target string, source string, dryRun bool) (*models.Job, error) {
+	job := &models.Job{
+		Target:   target,
+		Source:   source,
+		Status:   models.JobQueued,
+		DryRun:   dryRun,
+		QueuedAt: time.Now().UTC(),
+	}
+	id, err := db.InsertOne("jobs", job)
+	if err != nil {
+		return nil, err
+	}
+	job.ID = id
+	return job, nil
+}
+
+// The job an action runs under, nil if it was called directly rather than
+// through a WorkRequest
+func jobOf(f Fetcher) *models.Job {
+	if jf, ok := f.(jobFetcher); ok {
+		return jf.job
+	}
+	return nil
+}
+
+// Runs an action that was called directly as its own job
+func runAsJob(target string, f Fetcher, action func(Fetcher) error) error {
+	work := WorkRequest{Target: target, Fetcher: f, Action: action}
+	return work.DoWork()
+}
+
+// Returns an srm.ErrNotFound error if there's no such job
+func GetJob(id int) (*models.Job, error) {
+	return selectJob(global.Db, id)
+}
+
+func selectJob(db *srm.DbMap, id int) (*models.Job, error) {
+	job := &models.Job{}
+	query := fmt.Sprintf("SELECT * FROM jobs WHERE id = %s", db.Dialect.Placeholder(1))
+	err := db.SelectOne(job, query, id)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func startJob(job *models.Job) error {
+	job.Status = models.JobRunning
+	job.StartedAt = time.Now().UTC()
+	return updateJob(job)
+}
+
+func finishJob(job *models.Job, jobErr error) error {
+	job.Status = models.JobSucceeded
+	if job.DryRun {
+		job.Status = models.JobAwaitingApproval
+	}
+	if jobErr != nil {
+		job.Status = models.JobFailed
+		job.Error = jobErr.Error()
+	}
+	job.FinishedAt = time.Now().UTC()
+	err := updateJob(job)
+	if err != nil || job.DryRun || !datasetTargets[job.Target] {
+		return err
+	}
+
+	// A failed job may have written some rows before failing
+	err = bumpDatasetVersion(job)
+	cache.Publish(cache.Invalidation{Target: job.Target, JobID: job.ID})
+	return err
+}
+
+func updateJob(job *models.Job) error {
+	p := global.Db.Dialect.Placeholder
+	query := fmt.Sprintf("UPDATE jobs SET status = %s, started_at = %s, finished_at = %s, error = %s WHERE id = %s",
+		p(1), p(2), p(3), p(4), p(5))
+	_, err := global.Db.Exec(query, job.Status, job.StartedAt, job.FinishedAt, job.Error, job.ID)
+	return err
+}
+
+// Saves a dataset's quality report, returning an error if the share of
+// records breaking rules is over the abort threshold
+func saveQualityReport(job *models.Job, report *models.QualityReport) error {
+	report.JobID = job.ID
+	report.Aborted = report.ViolatingShare() > global.QualityAbortThreshold
+	_, err := global.Db.InsertOne("quality_reports", report)
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(report.Violations, func(i, j int) bool {
+		return report.Violations[i].Count > report.Violations[j].Count
+	})
+	for i := range report.Violations {
+		v := &report.Violations[i]
+		v.JobID = job.ID
+		v.FillSampleEpaIDsText()
+		_, err = global.Db.InsertOne("quality_violations", v)
+		if err != nil {
+			return err
+		}
+	}
+	jobLogger(job).Info("Data quality checked", "dataset", report.Dataset,
+		"violating", report.Violating, "checked", report.Checked)
+
+	if report.Aborted {
+		return fmt.Errorf("Ingest aborted: %d of %d %s records (%.1f%%) break data quality rules, over the %.1f%% threshold",
+			report.Violating, report.Checked, report.Dataset,
+			report.ViolatingShare()*100.0, global.QualityAbortThreshold*100.0)
+	}
+	return nil
+}
+
+// Quality reports made in a job, with their violations
+func GetQualityReports(jobID int) ([]models.QualityReport, error) {
+	reports := make([]models.QualityReport, 0)
+	query := fmt.Sprintf("SELECT * FROM quality_reports WHERE job_id = %s ORDER BY id",
+		global.Db.Dialect.Placeholder(1))
+	err := global.Db.SelectMany(&reports, query, jobID)
+	if err != nil {
+		return reports, err
+	}
+
+	violations := make([]models.QualityViolation, 0)
+	query = fmt.Sprintf("SELECT * FROM quality_violations WHERE job_id = %s ORDER BY count DESC, rule",
+		global.Db.Dialect.Placeholder(1))
+	err = global.Db.SelectMany(&violations, query, jobID)
+	if err != nil {
+		return reports, err
+	}
+	for i := range reports {
+		reports[i].Violations = make([]models.QualityViolation, 0)
+		for _, v := range violations {
+			if v.Dataset == reports[i].Dataset {
+				v.FillSampleEpaIDs()
+				reports[i].Violations = append(reports[i].Violations, v)
+			}
+		}
+	}
+	return reports, nil
+}
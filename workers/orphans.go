@@ -0,0 +1,64 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+// Inserts emissions info, keeping it as an orphan if we don't have its vehicle.
+// In a transaction the vehicle is looked up first, as a statement failing on
+// the foreign key would abort a postgres transaction.
+func insertEmissionsInfo(db *srm.DbMap, job *models.Job, raw *models.RawEmissionsInfo,
+	ei *models.EmissionsInfo) (orphaned bool, err error) {
+	if db.InTransaction() {
+		query := fmt.Sprintf("SELECT COUNT(*) FROM vehicles WHERE epa_id = %s", db.Dialect.Placeholder(1))
+		count, err := db.SelectInt(query, ei.EpaID)
+		if err != nil {
+			return false, err
+		}
+		orphaned = count == 0
+	}
+	if !orphaned {
+		_, err = db.InsertOne("emissions_info", ei)
+		if srm.ErrorKind(err) != srm.ErrForeignKeyViolation {
+			return false, err
+		}
+	}
+	orphan, err := models.NewEmissionsInfoOrphan(job.ID, ei.EpaID, raw)
+	if err != nil {
+		return true, err
+	}
+	_, err = db.InsertOne("emissions_info_orphans", orphan)
+	return true, err
+}
+
+// Moves orphaned emissions info whose vehicle has since been ingested into
+// emissions_info
+func relinkEmissionsInfoOrphans(db *srm.DbMap, job *models.Job) error {
+	orphans := make([]models.EmissionsInfoOrphan, 0)
+	err := db.SelectMany(&orphans,
+		"SELECT * FROM emissions_info_orphans WHERE epa_id IN (SELECT epa_id FROM vehicles) ORDER BY id")
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("DELETE FROM emissions_info_orphans WHERE id = %s", db.Dialect.Placeholder(1))
+	for i := range orphans {
+		ei, err := orphans[i].EmissionsInfo()
+		if err != nil {
+			return err
+		}
+		_, err = db.InsertOne("emissions_info", ei)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(query, orphans[i].ID)
+		if err != nil {
+			return err
+		}
+	}
+	jobLogger(job).Info("Emissions info orphans relinked", "count", len(orphans))
+	return nil
+}
@@ -0,0 +1,378 @@
+package workers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+const (
+	SnapshotDbFile       string = "fueleconomy.db"
+	SnapshotManifestFile string = "manifest.json"
+)
+
+var (
+	// Tables copied into snapshots, parents before children
+	SnapshotTables []string = []string{"vehicles", "emissions_info", "fuel_prices",
+		"search_terms", "vehicle_stats", "vehicle_rankings"}
+
+	// Older snapshots are deleted once a new one is made
+	SnapshotsRetained int = 5
+)
+
+// Queues a snapshot after a successful ingest. Never blocks the ingest.
+func queueSnapshot() {
+	select {
+	case WorkQueue <- WorkRequest{Target: "snapshot", Action: CreateSnapshot}:
+	default:
+		global.Logger.Warn("Work queue full, snapshot skipped")
+	}
+}
+
+// Copies the dataset into a new versioned snapshot directory. The snapshot is
+// built alongside and renamed into place so readers never see it half done.
+func CreateSnapshot(f Fetcher) error {
+	createdAt := time.Now().UTC()
+	version := models.NewSnapshotVersion(createdAt)
+	dir := filepath.Join(global.SnapshotDir, version)
+	partialDir := dir + ".partial"
+	err := os.MkdirAll(global.SnapshotDir, 0755)
+	if err != nil {
+		return err
+	}
+	// Versions are to the second, so a snapshot started in the same second as
+	// another fails rather than sharing or replacing its directory
+	if _, err = os.Stat(dir); err == nil {
+		return fmt.Errorf("Snapshot %s already exists", version)
+	}
+	err = os.Mkdir(partialDir, 0755)
+	if os.IsExist(err) {
+		return fmt.Errorf("Snapshot %s is already being created", version)
+	} else if err != nil {
+		return err
+	}
+	defer os.RemoveAll(partialDir)
+
+	manifest := models.SnapshotManifest{Version: version, CreatedAt: createdAt}
+	manifest.Tables, err = writeSnapshotData(partialDir)
+	if err != nil {
+		return err
+	}
+
+	names := []string{SnapshotDbFile}
+	for _, table := range SnapshotTables {
+		names = append(names, table+".ndjson.gz")
+	}
+	for _, name := range names {
+		file, err := describeSnapshotFile(filepath.Join(partialDir, name))
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(partialDir, SnapshotManifestFile), b, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(partialDir, dir)
+	if err != nil {
+		return err
+	}
+	jobLogger(jobOf(f)).Info("Snapshot created", "version", version)
+
+	return pruneSnapshots(jobOf(f))
+}
+
+// Snapshot manifests, newest first
+func ReadSnapshotManifests() ([]models.SnapshotManifest, error) {
+	manifests := make([]models.SnapshotManifest, 0)
+	versions, err := snapshotVersions()
+	if err != nil {
+		return manifests, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		manifest, err := ReadSnapshotManifest(versions[i])
+		if err != nil {
+			return manifests, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// Returns os.ErrNotExist if there's no such snapshot
+func ReadSnapshotManifest(version string) (manifest models.SnapshotManifest, err error) {
+	if !models.SnapshotVersionPattern.MatchString(version) {
+		return manifest, os.ErrNotExist
+	}
+	b, err := ioutil.ReadFile(filepath.Join(global.SnapshotDir, version, SnapshotManifestFile))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(b, &manifest)
+	return manifest, err
+}
+
+func SnapshotFilePath(version, name string) string {
+	return filepath.Join(global.SnapshotDir, version, name)
+}
+
+// Builds the SQLite database and NDJSON files in dir, returning row counts
+func writeSnapshotData(dir string) ([]models.SnapshotTable, error) {
+	tables := make([]models.SnapshotTable, 0)
+	dst, err := sql.Open("sqlite3", filepath.Join(dir, SnapshotDbFile))
+	if err != nil {
+		return tables, err
+	}
+	defer dst.Close()
+
+	err = global.CheckSqlite3Fts5(dst)
+	if err != nil {
+		return tables, err
+	}
+	err = applyMigrations(dst, filepath.Join(global.MigrationsDir, "sqlite3"))
+	if err != nil {
+		return tables, err
+	}
+
+	// Every table is read in one transaction, so they're consistent with each
+	// other. sqlite3 takes neither options, but its transactions are
+	// serializable anyway.
+	var opts *sql.TxOptions
+	if _, ok := global.Db.Dialect.(srm.Sqlite3Dialect); !ok {
+		opts = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	}
+	src, err := global.Db.Conn.BeginTx(context.Background(), opts)
+	if err != nil {
+		return tables, err
+	}
+	defer src.Rollback()
+
+	tx, err := dst.Begin()
+	if err != nil {
+		return tables, err
+	}
+	for _, table := range SnapshotTables {
+		rows, err := copySnapshotTable(src, tx, filepath.Join(dir, table+".ndjson.gz"), table)
+		if err != nil {
+			tx.Rollback()
+			return tables, err
+		}
+		tables = append(tables, models.SnapshotTable{Name: table, Rows: rows})
+	}
+	return tables, tx.Commit()
+}
+
+// Copies a table read in src into the snapshot database through tx and a
+// gzipped NDJSON file, one object per row keyed by column
+func copySnapshotTable(src *sql.Tx, tx *sql.Tx, ndjsonPath string, table string) (count int, err error) {
+	rows, err := src.Query(fmt.Sprintf("SELECT * FROM %s ORDER BY id", table))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	placeholders := make([]string, len(cols))
+	for i := range cols {
+		placeholders[i] = "?"
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(cols, ", "), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	file, err := os.Create(ndjsonPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	enc := json.NewEncoder(gz)
+
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return count, err
+		}
+		obj := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			// Drivers return text as bytes
+			if b, ok := vals[i].([]byte); ok {
+				vals[i] = string(b)
+			}
+			obj[col] = vals[i]
+		}
+		_, err = stmt.Exec(vals...)
+		if err != nil {
+			return count, err
+		}
+		err = enc.Encode(obj)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return count, err
+	}
+	return count, gz.Close()
+}
+
+// Runs the Up sections of sql-migrate migrations in a directory, in order
+func applyMigrations(db *sql.DB, dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("No migrations found in %s", dir)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return migrationNumber(paths[i]) < migrationNumber(paths[j])
+	})
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range migrationUpStatements(string(b)) {
+			_, err = db.Exec(stmt)
+			if err != nil {
+				return fmt.Errorf("%s: %s", filepath.Base(path), err)
+			}
+		}
+	}
+	return nil
+}
+
+func migrationNumber(path string) int {
+	n, _ := strconv.Atoi(strings.SplitN(filepath.Base(path), "_", 2)[0])
+	return n
+}
+
+// Splits a migration's Up section into statements on trailing semicolons,
+// keeping StatementBegin/StatementEnd blocks whole
+func migrationUpStatements(migration string) []string {
+	stmts := make([]string, 0)
+	buff := bytes.Buffer{}
+	flush := func() {
+		if stmt := strings.TrimSpace(buff.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		buff.Reset()
+	}
+	up, inBlock := false, false
+	for _, line := range strings.Split(migration, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "-- +migrate Up"):
+			up = true
+			continue
+		case strings.HasPrefix(trimmed, "-- +migrate Down"):
+			flush()
+			up = false
+			continue
+		case strings.HasPrefix(trimmed, "-- +migrate StatementBegin"):
+			inBlock = true
+			continue
+		case strings.HasPrefix(trimmed, "-- +migrate StatementEnd"):
+			inBlock = false
+			flush()
+			continue
+		}
+		if !up {
+			continue
+		}
+		buff.WriteString(line)
+		buff.WriteString("\n")
+		if !inBlock && strings.HasSuffix(trimmed, ";") {
+			flush()
+		}
+	}
+	flush()
+	return stmts
+}
+
+func describeSnapshotFile(path string) (models.SnapshotFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return models.SnapshotFile{}, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return models.SnapshotFile{}, err
+	}
+	return models.SnapshotFile{
+		Name:   filepath.Base(path),
+		Bytes:  n,
+		Sha256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// Completed snapshot versions, oldest first
+func snapshotVersions() ([]string, error) {
+	versions := make([]string, 0)
+	entries, err := ioutil.ReadDir(global.SnapshotDir)
+	if os.IsNotExist(err) {
+		return versions, nil
+	} else if err != nil {
+		return versions, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && models.SnapshotVersionPattern.MatchString(entry.Name()) {
+			versions = append(versions, entry.Name())
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+func pruneSnapshots(job *models.Job) error {
+	versions, err := snapshotVersions()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(versions)-SnapshotsRetained; i++ {
+		err = os.RemoveAll(filepath.Join(global.SnapshotDir, versions[i]))
+		if err != nil {
+			return err
+		}
+		jobLogger(job).Info("Snapshot deleted", "version", versions[i])
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+// Recomputes the vehicle_stats and vehicle_rankings tables from the current
+// active vehicles and fuel prices, so withdrawn and deleted vehicles are
+// neither counted nor ranked. Takes a Fetcher to be usable as a WorkRequest
+// Action, but fetches nothing.
+func ComputeStatistics(f Fetcher) error {
+	vs := make([]models.Vehicle, 0)
+	query := fmt.Sprintf("SELECT * FROM vehicles WHERE status = %s", global.Db.Dialect.Placeholder(1))
+	err := global.Db.SelectMany(&vs, query, models.VehicleActive)
+	if err != nil {
+		return err
+	}
+
+	fp := models.FuelPrices{}
+	fps := make([]models.FuelPrices, 0)
+	err = global.Db.SelectMany(&fps,
+		"SELECT * FROM fuel_prices WHERE updated = (SELECT MAX(updated) from fuel_prices)")
+	if err != nil {
+		return err
+	}
+	if len(fps) > 0 {
+		fp = fps[0]
+	}
+
+	profile := models.DrivingProfile{
+		CityShare:    models.CityShareDefault,
+		HighwayShare: models.HighwayShareDefault,
+		MilesPerYear: models.MilesPerYearDefault,
+	}
+	for i := range vs {
+		vs[i].Fuels = models.CalculateFuelData(&vs[i], profile, fp)
+	}
+
+	// Replaced in one transaction, so they're never seen part built
+	count := 0
+	rankings := models.NewVehicleRankings(vs)
+	err = global.Db.Transaction(nil, func(tx *srm.DbMap) error {
+		err := tx.DeleteAll("vehicle_stats")
+		if err != nil {
+			return err
+		}
+		for groupBy := range models.StatisticsGroupings {
+			for _, stat := range models.NewVehicleStats(groupBy, vs) {
+				_, err = tx.InsertOne("vehicle_stats", &stat)
+				if err != nil {
+					return err
+				}
+				count++
+			}
+		}
+
+		err = tx.DeleteAll("vehicle_rankings")
+		if err != nil {
+			return err
+		}
+		for i := range rankings {
+			_, err = tx.InsertOne("vehicle_rankings", &rankings[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	jobLogger(jobOf(f)).Info("Vehicle stats inserted", "count", count)
+	jobLogger(jobOf(f)).Info("Vehicle rankings inserted", "count", len(rankings))
+
+	return nil
+}
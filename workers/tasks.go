@@ -1,43 +1,133 @@
 package workers
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/metrics"
 	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+	"github.com/teasherm/fueleconomy/tracing"
 )
 
 type WorkRequest struct {
 	Target  string
 	Fetcher Fetcher
 	Action  func(Fetcher) error
+	Job     *models.Job // recorded when the work is done if nil
 }
 
+// Runs the action, tracking its progress in its job
 func (w *WorkRequest) DoWork() error {
-	return w.Action(w.Fetcher)
+	if w.Job == nil {
+		job, err := NewJob(global.Db, w.Target, "", false)
+		if err != nil {
+			return err
+		}
+		w.Job = job
+	}
+	err := startJob(w.Job)
+	if err != nil {
+		return err
+	}
+	ctx, span := tracing.Start(context.Background(), "job "+w.Target,
+		attribute.Int("job.id", w.Job.ID), attribute.String("job.target", w.Target),
+		attribute.String("job.source", w.Job.Source), attribute.Bool("job.dry_run", w.Job.DryRun))
+	err = w.Action(jobFetcher{w.Fetcher, w.Job, ctx})
+	if finishErr := finishJob(w.Job, err); finishErr != nil {
+		jobLogger(w.Job).Error("Job not finished", "error", finishErr)
+	}
+	span.SetAttributes(attribute.String("job.status", w.Job.Status))
+	tracing.SetError(span, err)
+	span.End()
+	metrics.Jobs.WithLabelValues(w.Target, w.Job.Status).Inc()
+	metrics.JobDuration.WithLabelValues(w.Target, w.Job.Status).
+		Observe(w.Job.FinishedAt.Sub(w.Job.StartedAt).Seconds())
+	return err
 }
 
-func GenerateWorkRequest(target string) (WorkRequest, error) {
+// Fetches EPA's CSV releases rather than XML
+const EPACSVSource string = "epa-csv"
+
+// Ingestion targets fetch from the EPA unless given a source: EPACSVSource,
+// or the name of a configured local mirror. Dry runs of vehicles and
+// emissions record the changes they would make for approval instead. The job
+// is recorded through db, so it can share a transaction with the caller's own
+// writes.
+func GenerateWorkRequest(db *srm.DbMap, target string, source string, dryRun bool) (WorkRequest, error) {
+	fileFetcher, restFetcher := Fetcher(FileFetcher{}), Fetcher(RestFetcher{})
+	if source == EPACSVSource {
+		fileFetcher = FileFetcher{Format: "csv"}
+	} else if source != "" {
+		mirror, ok := global.Mirrors[source]
+		if !ok {
+			return WorkRequest{}, errors.New(fmt.Sprintf("Ingestion source %s not configured", source))
+		}
+		localFetcher := NewLocalFetcher(mirror)
+		fileFetcher, restFetcher = localFetcher, localFetcher
+	}
+
+	var work WorkRequest
 	switch target {
 	case "vehicles":
-		return WorkRequest{
+		work = WorkRequest{
+			Target:  target,
+			Fetcher: fileFetcher,
+			Action:  IngestVehicles}
+	case "emissions":
+		work = WorkRequest{
 			Target:  target,
-			Fetcher: FileFetcher{},
-			Action:  IngestVehicles}, nil
+			Fetcher: fileFetcher,
+			Action:  ingestEmissionsInfo}
 	case "fuelprices":
-		return WorkRequest{
+		work = WorkRequest{
 			Target:  target,
-			Fetcher: RestFetcher{},
-			Action:  IngestFuelPrices}, nil
+			Fetcher: restFetcher,
+			Action:  IngestFuelPrices}
+	case "stats":
+		work = WorkRequest{
+			Target: target,
+			Action: ComputeStatistics}
+	case "snapshot":
+		work = WorkRequest{
+			Target: target,
+			Action: CreateSnapshot}
 	default:
 		return WorkRequest{}, errors.New(fmt.Sprintf("Ingestion target %s not valid", target))
 	}
+
+	if dryRun {
+		switch target {
+		case "vehicles":
+			work.Action = DryRunVehicles
+		case "emissions":
+			work.Action = DryRunEmissionsInfo
+		default:
+			return WorkRequest{}, errors.New(fmt.Sprintf("Dry run not supported for target %s", target))
+		}
+	}
+
+	job, err := NewJob(db, target, source, dryRun)
+	if err != nil {
+		return WorkRequest{}, err
+	}
+	work.Job = job
+	return work, nil
 }
 
 func IngestFuelPrices(f Fetcher) error {
+	if jobOf(f) == nil {
+		return runAsJob("fuelprices", f, IngestFuelPrices)
+	}
+
 	data, err := f.Fetch("https://www.fueleconomy.gov/ws/rest/fuelprices")
 	if err != nil {
 		return err
@@ -50,29 +140,41 @@ func IngestFuelPrices(f Fetcher) error {
 	if err != nil {
 		return err
 	}
-	global.Logger.Println("Fuel Prices Inserted With ID:", insertedId)
+	jobLogger(jobOf(f)).Info("Fuel prices inserted", "id", insertedId)
 
+	// Fuel cost rankings depend on fuel prices
+	err = ComputeStatistics(f)
+	if err != nil {
+		return err
+	}
+	queueSnapshot()
 	return nil
 }
 
 func IngestVehicles(f Fetcher) error {
-	data, err := f.Fetch("vehicles")
+	job := jobOf(f)
+	if job == nil {
+		return runAsJob("vehicles", f, IngestVehicles)
+	}
+
+	// Both releases are checked before either is written
+	_, vehicles, err := prepareVehicles(f, job)
 	if err != nil {
 		return err
 	}
-
-	var rvo models.RawVehiclesOuter
-	err = xml.Unmarshal(data, &rvo)
+	raws, emissionsInfoes, err := prepareEmissionsInfoes(f, job)
 	if err != nil {
 		return err
 	}
 
 	insertedIds := make([]int, 0)
+	termFrequencies := make(map[string]int)
 	count := 0
-	for _, vehicle := range rvo.RawVehicles {
-		fv, err := models.NewVehicleFromRaw(&vehicle)
-		if err != nil {
-			return err
+	seenAt := time.Now().UTC()
+	for _, fv := range vehicles {
+		fv.LastSeenInFeed = seenAt
+		for _, term := range strings.Fields(fv.SearchDocument) {
+			termFrequencies[term]++
 		}
 		insertedId, err := global.Db.UpsertOne("vehicles", "epa_id", fv)
 		if err != nil {
@@ -85,52 +187,148 @@ func IngestVehicles(f Fetcher) error {
 	}
 	inserted := len(insertedIds)
 	updated := count - len(insertedIds)
-	global.Logger.Println("Vehicle Updates:", updated)
-	global.Logger.Println("Vehicle Inserts:", inserted)
-	err = ingestEmissionsInfo(f)
+	jobLogger(job).Info("Vehicles upserted", "updated", updated, "inserted", inserted)
+	err = withdrawMissingVehicles(job, seenAt, count)
+	if err != nil {
+		return err
+	}
+	err = relinkEmissionsInfoOrphans(global.Db, job)
 	if err != nil {
 		return err
 	}
+	err = rebuildSearchTerms(job, termFrequencies)
+	if err != nil {
+		return err
+	}
+	err = replaceEmissionsInfo(job, raws, emissionsInfoes)
+	if err != nil {
+		return err
+	}
+	err = ComputeStatistics(f)
+	if err != nil {
+		return err
+	}
+	queueSnapshot()
 	return nil
 }
 
-func ingestEmissionsInfo(f Fetcher) error {
+// Fetches, converts and checks the quality of a vehicles release. Everything
+// is checked before anything is written so an aborted ingest leaves the data
+// as it was.
+func prepareVehicles(f Fetcher, job *models.Job) ([]models.RawVehicle, []*models.Vehicle, error) {
+	data, err := f.Fetch("vehicles")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawVehicles, err := models.DecodeRawVehicles(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report := models.NewQualityReport("vehicles")
+	vehicles := make([]*models.Vehicle, 0, len(rawVehicles))
+	for i := range rawVehicles {
+		fv, err := models.NewVehicleFromRaw(&rawVehicles[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		report.AddRecord(fv.EpaID, models.CheckVehicleQuality(&rawVehicles[i], fv))
+		vehicles = append(vehicles, fv)
+	}
+	return rawVehicles, vehicles, saveQualityReport(job, report)
+}
+
+// Fetches, converts and checks the quality of an emissions release. Records
+// that can't be converted are left out of both returned slices.
+func prepareEmissionsInfoes(f Fetcher, job *models.Job) ([]models.RawEmissionsInfo, []*models.EmissionsInfo, error) {
 	data, err := f.Fetch("emissions")
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	var rvo models.RawEmissionsInfoOuter
-	err = xml.Unmarshal(data, &rvo)
+	rawEmissionsInfoes, err := models.DecodeRawEmissionsInfoes(data)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	global.Db.DeleteAll("emissions_info")
-	insertedIds := make([]int, 0)
-	violatingIds := make(map[int]int)
-	for _, emissionsInfo := range rvo.RawEmissionsInfoes {
-		ei, err := models.NewEmissionsInfoFromRaw(&emissionsInfo)
-		insertedId, err := global.Db.InsertOne("emissions_info", ei)
+	report := models.NewQualityReport("emissions")
+	raws := make([]models.RawEmissionsInfo, 0, len(rawEmissionsInfoes))
+	emissionsInfoes := make([]*models.EmissionsInfo, 0, len(rawEmissionsInfoes))
+	for i := range rawEmissionsInfoes {
+		raw := &rawEmissionsInfoes[i]
+		ei, err := models.NewEmissionsInfoFromRaw(raw)
+		epaID, _ := strconv.Atoi(raw.EpaID)
+		report.AddRecord(epaID, models.CheckEmissionsInfoQuality(raw, ei, err))
+		if err == nil {
+			raws = append(raws, *raw)
+			emissionsInfoes = append(emissionsInfoes, ei)
+		}
+	}
+	return raws, emissionsInfoes, saveQualityReport(job, report)
+}
+
+// Search terms are the vocabulary typo tolerant searches are corrected against.
+// They're replaced in one transaction, so searches never see them part built.
+func rebuildSearchTerms(job *models.Job, termFrequencies map[string]int) error {
+	err := global.Db.Transaction(nil, func(tx *srm.DbMap) error {
+		err := tx.DeleteAll("search_terms")
 		if err != nil {
-			if strings.Contains(err.Error(), "violates foreign key constraint") {
-				if count, ok := violatingIds[ei.EpaID]; ok {
-					violatingIds[ei.EpaID] = count + 1
-				} else {
-					violatingIds[ei.EpaID] = 1
-				}
-				continue
-			} else {
+			return err
+		}
+		for term, frequency := range termFrequencies {
+			_, err = tx.InsertOne("search_terms", &models.SearchTerm{Term: term, Frequency: frequency})
+			if err != nil {
 				return err
 			}
 		}
-		if insertedId > 0 {
-			insertedIds = append(insertedIds, insertedId)
-		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	inserted := len(insertedIds)
-	global.Logger.Println("Emissions Info Inserts:", inserted)
-	global.Logger.Println("Emissions Info Foreign Key Violations[id:count]:", violatingIds)
+	jobLogger(job).Info("Search terms inserted", "count", len(termFrequencies))
+	return nil
+}
+
+func ingestEmissionsInfo(f Fetcher) error {
+	job := jobOf(f)
+	if job == nil {
+		return runAsJob("emissions", f, ingestEmissionsInfo)
+	}
+
+	raws, emissionsInfoes, err := prepareEmissionsInfoes(f, job)
+	if err != nil {
+		return err
+	}
+	return replaceEmissionsInfo(job, raws, emissionsInfoes)
+}
 
+// Writes a prepared emissions release in place of what we have, orphans
+// included
+func replaceEmissionsInfo(job *models.Job, raws []models.RawEmissionsInfo,
+	emissionsInfoes []*models.EmissionsInfo) error {
+	err := global.Db.DeleteAll("emissions_info")
+	if err != nil {
+		return err
+	}
+	err = global.Db.DeleteAll("emissions_info_orphans")
+	if err != nil {
+		return err
+	}
+	inserted := 0
+	orphanedIds := make(map[int]int)
+	for i, ei := range emissionsInfoes {
+		orphaned, err := insertEmissionsInfo(global.Db, job, &raws[i], ei)
+		if err != nil {
+			return err
+		}
+		if orphaned {
+			orphanedIds[ei.EpaID]++
+		} else {
+			inserted++
+		}
+	}
+	jobLogger(job).Info("Emissions info inserted", "inserted", inserted, "orphaned", orphanedIds)
 	return nil
 }
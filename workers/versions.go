@@ -0,0 +1,40 @@
+package workers
+
+import (
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+	"github.com/teasherm/fueleconomy/srm"
+)
+
+// Targets whose jobs write the dataset, rather than only recording changes or
+// reading it
+var datasetTargets map[string]bool = map[string]bool{
+	"vehicles":   true,
+	"emissions":  true,
+	"approve":    true,
+	"fuelprices": true,
+	"stats":      true,
+}
+
+// Records that a job changed the dataset, invalidating cached responses
+func bumpDatasetVersion(job *models.Job) error {
+	version := &models.DatasetVersion{
+		JobID:     job.ID,
+		Target:    job.Target,
+		CreatedAt: job.FinishedAt,
+	}
+	_, err := global.Db.InsertOne("dataset_versions", version)
+	return err
+}
+
+// The latest dataset version, version 0 if nothing has been ingested
+func GetDatasetVersion() (*models.DatasetVersion, error) {
+	version := &models.DatasetVersion{}
+	err := global.Db.SelectOne(version, "SELECT * FROM dataset_versions ORDER BY id DESC LIMIT 1")
+	if srm.ErrorKind(err) == srm.ErrNotFound {
+		return version, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
@@ -0,0 +1,62 @@
+package workers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teasherm/fueleconomy/global"
+	"github.com/teasherm/fueleconomy/models"
+)
+
+// A vehicles feed with fewer vehicles than this share of those active is
+// taken to be truncated, and nothing missing from it is withdrawn
+var CompleteFeedShare float64 = 0.5
+
+// Withdraws active vehicles that weren't in a complete feed seen at seenAt, as
+// of then, and soft deletes those withdrawn for longer than the grace period
+func withdrawMissingVehicles(job *models.Job, seenAt time.Time, feedCount int) error {
+	p := global.Db.Dialect.Placeholder
+	query := fmt.Sprintf("SELECT COUNT(*) FROM vehicles WHERE status = %s", p(1))
+	active, err := global.Db.SelectInt(query, models.VehicleActive)
+	if err != nil {
+		return err
+	}
+	if !feedComplete(job, feedCount, active) {
+		return deleteExpiredWithdrawals(job, seenAt)
+	}
+
+	query = fmt.Sprintf("UPDATE vehicles SET status = %s, withdrawn_at = %s "+
+		"WHERE status = %s AND last_seen_in_feed < %s", p(1), p(2), p(3), p(4))
+	result, err := global.Db.Exec(query, models.VehicleWithdrawn, seenAt, models.VehicleActive, seenAt)
+	if err != nil {
+		return err
+	}
+	withdrawn, _ := result.RowsAffected()
+	jobLogger(job).Info("Vehicles withdrawn", "count", withdrawn)
+	return deleteExpiredWithdrawals(job, seenAt)
+}
+
+// Whether a feed of feedCount vehicles is complete given the number active,
+// warning that none will be withdrawn if it isn't
+func feedComplete(job *models.Job, feedCount int, active int) bool {
+	if float64(feedCount) < CompleteFeedShare*float64(active) {
+		jobLogger(job).Warn("Vehicle feed incomplete, none withdrawn", "feed_count", feedCount, "active", active)
+		return false
+	}
+	return true
+}
+
+// Soft deletes vehicles withdrawn over the grace period before now
+func deleteExpiredWithdrawals(job *models.Job, now time.Time) error {
+	p := global.Db.Dialect.Placeholder
+	query := fmt.Sprintf("UPDATE vehicles SET status = %s WHERE status = %s AND withdrawn_at < %s",
+		p(1), p(2), p(3))
+	result, err := global.Db.Exec(query, models.VehicleDeleted, models.VehicleWithdrawn,
+		now.Add(-global.WithdrawnGracePeriod))
+	if err != nil {
+		return err
+	}
+	deleted, _ := result.RowsAffected()
+	jobLogger(job).Info("Withdrawn vehicles deleted", "count", deleted)
+	return nil
+}
@@ -1,10 +1,8 @@
 package workers
 
 import (
-	"fmt"
+	"sync/atomic"
 	"time"
-
-	"github.com/teasherm/fueleconomy/global"
 )
 
 func NewWorker(id int, workerQueue chan chan WorkRequest) Worker {
@@ -31,16 +29,16 @@ func (w Worker) Start() {
 
 			select {
 			case work := <-w.Work:
+				atomic.AddInt64(&nBusy, 1)
 				startTime := time.Now()
 				err := work.DoWork()
+				atomic.AddInt64(&nBusy, -1)
+				logger := workLogger(work).With("worker", w.ID,
+					"elapsed", time.Since(startTime).String())
 				if err != nil {
-					global.Logger.Println(err)
-					global.Logger.Println("Task failed:", work.Target)
+					logger.Error("Job failed", "error", err)
 				} else {
-					global.Logger.Println("Task succeeded:", work.Target)
-					endTime := time.Now()
-					global.Logger.Println(fmt.Sprintf("Time elapsed for target %s: %v",
-						work.Target, endTime.Sub(startTime)))
+					logger.Info("Job succeeded")
 				}
 			case <-w.QuitChan:
 				return